@@ -0,0 +1,171 @@
+package vspherejanitor
+
+import (
+	"context"
+	"time"
+
+	"github.com/honeycombio/libhoney-go"
+	"github.com/rcrowley/go-metrics"
+	"github.com/travis-ci/vsphere-janitor/log"
+)
+
+func (r *Resources) add(other Resources) {
+	r.CPUs += other.CPUs
+	r.MemoryMB += other.MemoryMB
+	r.StorageCommitted += other.StorageCommitted
+	r.StorageProvisioned += other.StorageProvisioned
+	r.CPUHours += other.CPUHours
+}
+
+// reclaimed returns the resources that cleaning up vm with action frees.
+// Only a VM that was powered on or suspended frees its CPUs and memory. With
+// SkipDestroy, a VM is only powered off, which frees nothing else, and an
+// unregistered VM only frees storage if its files are deleted. CPUHours are
+// the vCPUs of a powered on VM times how long it was up past cutoff.
+func (j *view) reclaimed(vm VirtualMachine, action Action, cutoff time.Duration) Resources {
+	resources := vm.Resources()
+
+	switch {
+	case action == ActionDestroy && !j.opts.SkipDestroy:
+	case action == ActionDestroy:
+		resources = Resources{CPUs: resources.CPUs, MemoryMB: resources.MemoryMB}
+	case action == ActionUnregister && !j.opts.SkipDestroy:
+		if !j.opts.UnregisterDeleteFiles {
+			resources.StorageCommitted = 0
+			resources.StorageProvisioned = 0
+		}
+	default:
+		return Resources{}
+	}
+
+	switch vm.PowerState() {
+	case PowerStateOn:
+		if pastCutoff := vm.Uptime() - cutoff; pastCutoff > 0 {
+			resources.CPUHours = float64(resources.CPUs) * pastCutoff.Hours()
+		}
+	case PowerStateSuspended:
+	default:
+		resources.CPUs = 0
+		resources.MemoryMB = 0
+	}
+
+	return resources
+}
+
+// recordReclaimed adds resources reclaimed in path to the totals reported
+// as metrics, and to the event of the VM they were reclaimed from.
+func (j *Janitor) recordReclaimed(path string, resources Resources, event *libhoney.Event) {
+	event.AddField("app.reclaimed_cpus", resources.CPUs)
+	event.AddField("app.reclaimed_memory_mb", resources.MemoryMB)
+	event.AddField("app.reclaimed_storage_committed_bytes", resources.StorageCommitted)
+	event.AddField("app.reclaimed_storage_provisioned_bytes", resources.StorageProvisioned)
+	event.AddField("app.reclaimed_cpu_hours", resources.CPUHours)
+
+	for _, prefix := range []string{"vsphere.janitor.reclaimed.", "vsphere.janitor.cleanup.path." + metricName(path) + ".reclaimed."} {
+		metrics.GetOrRegisterCounter(prefix+"cpus", j.metrics).Inc(int64(resources.CPUs))
+		metrics.GetOrRegisterCounter(prefix+"memory_mb", j.metrics).Inc(int64(resources.MemoryMB))
+		metrics.GetOrRegisterCounter(prefix+"storage_committed_bytes", j.metrics).Inc(resources.StorageCommitted)
+		metrics.GetOrRegisterCounter(prefix+"storage_provisioned_bytes", j.metrics).Inc(resources.StorageProvisioned)
+		metrics.GetOrRegisterCounter(prefix+"cpu_seconds", j.metrics).Inc(int64(resources.CPUHours * 3600))
+	}
+}
+
+// addResourceFields adds the resources allocated to vm to its event.
+func addResourceFields(event *libhoney.Event, vm VirtualMachine) {
+	resources := vm.Resources()
+	event.AddField("app.cpus", resources.CPUs)
+	event.AddField("app.memory_mb", resources.MemoryMB)
+	event.AddField("app.storage_committed_bytes", resources.StorageCommitted)
+	event.AddField("app.storage_provisioned_bytes", resources.StorageProvisioned)
+}
+
+// summarize logs and sends an event with the outcome of cleaning up a path,
+// including the resources reclaimed.
+func (j *Janitor) summarize(ctx context.Context, result PathResult) {
+	log.WithContext(ctx).
+		WithField("path", result.Path).
+		WithField("vms", result.VMs).
+		WithField("destroyed", result.Destroyed).
+		WithField("unregistered", result.Unregistered).
+		WithField("failed", result.Failed).
+		WithField("skipped", result.Skipped).
+		WithField("reclaimed_cpus", result.Reclaimed.CPUs).
+		WithField("reclaimed_memory_mb", result.Reclaimed.MemoryMB).
+		WithField("reclaimed_storage_committed_bytes", result.Reclaimed.StorageCommitted).
+		WithField("reclaimed_storage_provisioned_bytes", result.Reclaimed.StorageProvisioned).
+		WithField("reclaimed_cpu_hours", result.Reclaimed.CPUHours).
+		Info("finished cleaning up path")
+
+	event := libhoney.NewEvent()
+	event.AddField("meta.type", "cleanup_summary")
+	event.AddField("app.path", result.Path)
+	event.AddField("app.duration_ms", result.Duration/time.Millisecond)
+	event.AddField("app.vms", result.VMs)
+	event.AddField("app.destroyed", result.Destroyed)
+	event.AddField("app.unregistered", result.Unregistered)
+	event.AddField("app.failed", result.Failed)
+	event.AddField("app.skipped", result.Skipped)
+	event.AddField("app.observe_only", result.ObserveOnly)
+	event.AddField("app.pressure_mode", result.PressureMode)
+	event.AddField("app.reclaimed_cpus", result.Reclaimed.CPUs)
+	event.AddField("app.reclaimed_memory_mb", result.Reclaimed.MemoryMB)
+	event.AddField("app.reclaimed_storage_committed_bytes", result.Reclaimed.StorageCommitted)
+	event.AddField("app.reclaimed_storage_provisioned_bytes", result.Reclaimed.StorageProvisioned)
+	event.AddField("app.reclaimed_cpu_hours", result.Reclaimed.CPUHours)
+	if result.Err != "" {
+		event.AddField("app.err", result.Err)
+	}
+	event.Send()
+}
+
+// A cycleSummary adds up the outcomes of cleaning up the paths of a cycle.
+type cycleSummary struct {
+	start time.Time
+	paths int
+
+	vms, destroyed, unregistered, failed, skipped int
+	reclaimed                                     Resources
+}
+
+func (s *cycleSummary) add(result PathResult) {
+	s.vms += result.VMs
+	s.destroyed += result.Destroyed
+	s.unregistered += result.Unregistered
+	s.failed += result.Failed
+	s.skipped += result.Skipped
+	s.reclaimed.add(result.Reclaimed)
+}
+
+// summarizeCycle logs and sends an event with the outcome of cleaning up all
+// paths of a cycle, including the resources reclaimed.
+func (j *Janitor) summarizeCycle(ctx context.Context, summary cycleSummary) {
+	log.WithContext(ctx).
+		WithField("paths", summary.paths).
+		WithField("vms", summary.vms).
+		WithField("destroyed", summary.destroyed).
+		WithField("unregistered", summary.unregistered).
+		WithField("failed", summary.failed).
+		WithField("skipped", summary.skipped).
+		WithField("reclaimed_cpus", summary.reclaimed.CPUs).
+		WithField("reclaimed_memory_mb", summary.reclaimed.MemoryMB).
+		WithField("reclaimed_storage_committed_bytes", summary.reclaimed.StorageCommitted).
+		WithField("reclaimed_storage_provisioned_bytes", summary.reclaimed.StorageProvisioned).
+		WithField("reclaimed_cpu_hours", summary.reclaimed.CPUHours).
+		Info("finished cleaning up paths")
+
+	event := libhoney.NewEvent()
+	event.AddField("meta.type", "cycle_summary")
+	event.AddField("app.duration_ms", time.Since(summary.start)/time.Millisecond)
+	event.AddField("app.paths", summary.paths)
+	event.AddField("app.vms", summary.vms)
+	event.AddField("app.destroyed", summary.destroyed)
+	event.AddField("app.unregistered", summary.unregistered)
+	event.AddField("app.failed", summary.failed)
+	event.AddField("app.skipped", summary.skipped)
+	event.AddField("app.reclaimed_cpus", summary.reclaimed.CPUs)
+	event.AddField("app.reclaimed_memory_mb", summary.reclaimed.MemoryMB)
+	event.AddField("app.reclaimed_storage_committed_bytes", summary.reclaimed.StorageCommitted)
+	event.AddField("app.reclaimed_storage_provisioned_bytes", summary.reclaimed.StorageProvisioned)
+	event.AddField("app.reclaimed_cpu_hours", summary.reclaimed.CPUHours)
+	event.Send()
+}
@@ -0,0 +1,234 @@
+package vspherejanitor
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rcrowley/go-metrics"
+)
+
+// AdaptiveThrottleOpts configures an AdaptiveThrottle.
+type AdaptiveThrottleOpts struct {
+	// LatencyThreshold is how long a power off or destroy may take before
+	// it counts as a sign of an overloaded vCenter.
+	LatencyThreshold time.Duration
+
+	// DecreaseFactor is what the limits are multiplied with when vCenter
+	// looks overloaded, e.g. 0.5 to halve them.
+	DecreaseFactor float64
+
+	// IncreaseWindow is the number of healthy operations in a row after
+	// which the limits are increased by one step.
+	IncreaseWindow int
+
+	// Cooldown is the minimum time between two decreases, so a burst of
+	// failures of operations that were already running only counts once.
+	Cooldown time.Duration
+
+	// MinConcurrency and MinRate are the lowest the limits will go.
+	MinConcurrency int
+	MinRate        float64
+
+	// Metrics is the registry the current limits are reported to. It
+	// defaults to metrics.DefaultRegistry.
+	Metrics metrics.Registry
+}
+
+func (o *AdaptiveThrottleOpts) validate() error {
+	if o.DecreaseFactor <= 0 || o.DecreaseFactor >= 1 {
+		return errors.Errorf("adaptive throttle decrease factor must be between 0 and 1, but was %v", o.DecreaseFactor)
+	}
+
+	if o.LatencyThreshold < 0 {
+		return errors.Errorf("adaptive throttle latency threshold must not be negative, but was %v", o.LatencyThreshold)
+	}
+
+	if o.Cooldown < 0 {
+		return errors.Errorf("adaptive throttle cooldown must not be negative, but was %v", o.Cooldown)
+	}
+
+	if o.IncreaseWindow < 0 || o.MinConcurrency < 0 || o.MinRate < 0 {
+		return errors.New("adaptive throttle increase window, min concurrency and min rate must not be negative")
+	}
+
+	return nil
+}
+
+// adjustableRateLimiter is a RateLimiter whose rate can be changed, such as
+// a TokenBucket.
+type adjustableRateLimiter interface {
+	RateLimiter
+	SetRate(rate float64)
+	Rate() float64
+}
+
+// An AdaptiveThrottle limits concurrent operations and adjusts that limit,
+// and the rate of its rate limiter if it is adjustable, in an additive
+// increase/multiplicative decrease fashion based on observed operation
+// latency and faults.
+type AdaptiveThrottle struct {
+	opts           AdaptiveThrottleOpts
+	maxConcurrency int
+	maxRate        float64
+	rateLimiter    adjustableRateLimiter
+
+	mutex        sync.Mutex
+	concurrency  int
+	inUse        int
+	changed      chan struct{}
+	healthy      int
+	lastDecrease time.Time
+}
+
+// NewAdaptiveThrottle returns an AdaptiveThrottle that starts at, and never
+// goes above, maxConcurrency and the current rate of rateLimiter.
+func NewAdaptiveThrottle(opts *AdaptiveThrottleOpts, maxConcurrency int, rateLimiter RateLimiter) *AdaptiveThrottle {
+	at := &AdaptiveThrottle{
+		opts:           *opts,
+		maxConcurrency: maxConcurrency,
+		concurrency:    maxConcurrency,
+		changed:        make(chan struct{}),
+	}
+
+	if at.opts.MinConcurrency < 1 {
+		at.opts.MinConcurrency = 1
+	}
+	if at.maxConcurrency < at.opts.MinConcurrency {
+		at.maxConcurrency = at.opts.MinConcurrency
+		at.concurrency = at.opts.MinConcurrency
+	}
+	if at.opts.IncreaseWindow < 1 {
+		at.opts.IncreaseWindow = 1
+	}
+	if at.opts.Metrics == nil {
+		at.opts.Metrics = metrics.DefaultRegistry
+	}
+
+	if arl, ok := rateLimiter.(adjustableRateLimiter); ok {
+		at.rateLimiter = arl
+		at.maxRate = arl.Rate()
+	}
+
+	at.updateMetrics()
+	return at
+}
+
+// Acquire blocks until fewer operations than the current limit are running,
+// or ctx is done.
+func (at *AdaptiveThrottle) Acquire(ctx context.Context) error {
+	for {
+		at.mutex.Lock()
+		if at.inUse < at.concurrency {
+			at.inUse++
+			at.mutex.Unlock()
+			return nil
+		}
+		changed := at.changed
+		at.mutex.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// Release marks an operation started with Acquire as finished.
+func (at *AdaptiveThrottle) Release() {
+	at.mutex.Lock()
+	defer at.mutex.Unlock()
+
+	at.inUse--
+	at.broadcast()
+}
+
+// Observe records the outcome of an operation. Operations that were slow or
+// failed with a transient fault or a timeout decrease the limits, and a
+// window of healthy operations increases them again.
+func (at *AdaptiveThrottle) Observe(latency time.Duration, err error) {
+	overloaded := (err != nil && (IsTransient(err) || IsTimeout(err))) ||
+		(at.opts.LatencyThreshold > 0 && latency > at.opts.LatencyThreshold)
+
+	at.mutex.Lock()
+	defer at.mutex.Unlock()
+
+	if overloaded {
+		at.healthy = 0
+		if time.Since(at.lastDecrease) < at.opts.Cooldown {
+			return
+		}
+		at.lastDecrease = time.Now()
+		at.decrease()
+	} else if err == nil {
+		at.healthy++
+		if at.healthy < at.opts.IncreaseWindow {
+			return
+		}
+		at.healthy = 0
+		at.increase()
+	} else {
+		return
+	}
+
+	at.broadcast()
+	at.updateMetrics()
+}
+
+// Limits returns the current effective concurrency and rate limits. The
+// rate is zero if the rate limiter isn't adjustable.
+func (at *AdaptiveThrottle) Limits() (int, float64) {
+	at.mutex.Lock()
+	defer at.mutex.Unlock()
+
+	rate := 0.0
+	if at.rateLimiter != nil {
+		rate = at.rateLimiter.Rate()
+	}
+	return at.concurrency, rate
+}
+
+func (at *AdaptiveThrottle) decrease() {
+	at.concurrency = int(float64(at.concurrency) * at.opts.DecreaseFactor)
+	if at.concurrency < at.opts.MinConcurrency {
+		at.concurrency = at.opts.MinConcurrency
+	}
+
+	if at.rateLimiter != nil {
+		rate := at.rateLimiter.Rate() * at.opts.DecreaseFactor
+		if rate < at.opts.MinRate {
+			rate = at.opts.MinRate
+		}
+		at.rateLimiter.SetRate(rate)
+	}
+}
+
+func (at *AdaptiveThrottle) increase() {
+	if at.concurrency < at.maxConcurrency {
+		at.concurrency++
+	}
+
+	if at.rateLimiter != nil {
+		rate := at.rateLimiter.Rate() + 1
+		if rate > at.maxRate {
+			rate = at.maxRate
+		}
+		at.rateLimiter.SetRate(rate)
+	}
+}
+
+// broadcast wakes up everyone waiting in Acquire. It must be called with
+// the mutex held.
+func (at *AdaptiveThrottle) broadcast() {
+	close(at.changed)
+	at.changed = make(chan struct{})
+}
+
+func (at *AdaptiveThrottle) updateMetrics() {
+	metrics.GetOrRegisterGauge("vsphere.janitor.throttle.concurrency", at.opts.Metrics).Update(int64(at.concurrency))
+	if at.rateLimiter != nil {
+		metrics.GetOrRegisterGaugeFloat64("vsphere.janitor.throttle.rate", at.opts.Metrics).Update(at.rateLimiter.Rate())
+	}
+}
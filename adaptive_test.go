@@ -0,0 +1,70 @@
+package vspherejanitor_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	vspherejanitor "github.com/travis-ci/vsphere-janitor"
+)
+
+func TestAdaptiveThrottleAIMD(t *testing.T) {
+	tb := vspherejanitor.NewTokenBucket(8, 1)
+	at := vspherejanitor.NewAdaptiveThrottle(&vspherejanitor.AdaptiveThrottleOpts{
+		LatencyThreshold: time.Second,
+		DecreaseFactor:   0.5,
+		IncreaseWindow:   2,
+		MinRate:          1,
+	}, 8, tb)
+
+	concurrency, rate := at.Limits()
+	assertEqual(t, "initial concurrency", 8, concurrency)
+	assertEqual(t, "initial rate", 8.0, rate)
+
+	at.Observe(time.Millisecond, transientError{})
+	concurrency, rate = at.Limits()
+	assertEqual(t, "concurrency after transient fault", 4, concurrency)
+	assertEqual(t, "rate after transient fault", 4.0, rate)
+
+	at.Observe(2*time.Second, nil)
+	concurrency, rate = at.Limits()
+	assertEqual(t, "concurrency after slow operation", 2, concurrency)
+	assertEqual(t, "rate after slow operation", 2.0, rate)
+
+	at.Observe(time.Millisecond, errors.New("permanent"))
+	concurrency, _ = at.Limits()
+	assertEqual(t, "concurrency after permanent fault", 2, concurrency)
+
+	for i := 0; i < 4; i++ {
+		at.Observe(time.Millisecond, nil)
+	}
+	concurrency, rate = at.Limits()
+	assertEqual(t, "concurrency after healthy operations", 4, concurrency)
+	assertEqual(t, "rate after healthy operations", 4.0, rate)
+
+	for i := 0; i < 20; i++ {
+		at.Observe(time.Millisecond, nil)
+	}
+	concurrency, rate = at.Limits()
+	assertEqual(t, "concurrency is capped", 8, concurrency)
+	assertEqual(t, "rate is capped", 8.0, rate)
+}
+
+func TestAdaptiveThrottleAcquire(t *testing.T) {
+	at := vspherejanitor.NewAdaptiveThrottle(&vspherejanitor.AdaptiveThrottleOpts{
+		DecreaseFactor: 0.5,
+	}, 1, nil)
+
+	err := at.Acquire(context.TODO())
+	assertOk(t, "first at.Acquire()", err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = at.Acquire(ctx)
+	assertError(t, "second at.Acquire() while limit is reached", err)
+
+	at.Release()
+	err = at.Acquire(context.TODO())
+	assertOk(t, "at.Acquire() after release", err)
+}
@@ -0,0 +1,325 @@
+package vspherejanitor
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/travis-ci/vsphere-janitor/log"
+)
+
+// An AdminTarget is a janitor controlled through the admin API, with the
+// scheduler running its cycles and the paths it cleans up.
+type AdminTarget struct {
+	Janitor   *Janitor
+	Scheduler *Scheduler
+	Paths     func() []string
+}
+
+// Admin is an http.Handler serving an API to control janitors while they
+// run: pausing and resuming them, triggering cycles, exempting VMs and
+// looking at their configuration and state. Every request must carry the
+// token as a bearer token.
+//
+// Requests that act on janitors take an optional endpoint query parameter
+// to only act on the janitor registered under that name, and act on all of
+// them otherwise.
+type Admin struct {
+	token string
+	mux   *http.ServeMux
+
+	mutex   sync.Mutex
+	targets map[string]*AdminTarget
+}
+
+// NewAdmin returns an Admin without any janitors that accepts requests
+// with token.
+func NewAdmin(token string) *Admin {
+	a := &Admin{
+		token:   token,
+		mux:     http.NewServeMux(),
+		targets: make(map[string]*AdminTarget),
+	}
+
+	a.mux.HandleFunc("/admin/status", a.handleStatus)
+	a.mux.HandleFunc("/admin/config", a.handleConfig)
+	a.mux.HandleFunc("/admin/pause", a.handlePause)
+	a.mux.HandleFunc("/admin/resume", a.handleResume)
+	a.mux.HandleFunc("/admin/trigger", a.handleTrigger)
+	a.mux.HandleFunc("/admin/exemptions", a.handleExemptions)
+	a.mux.HandleFunc("/admin/zero-uptime", a.handleZeroUptime)
+
+	return a
+}
+
+// Register adds target under name, replacing any target registered under
+// the same name before.
+func (a *Admin) Register(name string, target *AdminTarget) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.targets[name] = target
+}
+
+func (a *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if a.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="vsphere-janitor"`)
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		return
+	}
+
+	a.mux.ServeHTTP(w, r)
+}
+
+// selectTargets returns the targets a request acts on, by name, writing an
+// error response and returning false if there is no such target.
+func (a *Admin) selectTargets(w http.ResponseWriter, r *http.Request) (map[string]*AdminTarget, bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	name := r.URL.Query().Get("endpoint")
+	if name == "" {
+		targets := make(map[string]*AdminTarget, len(a.targets))
+		for name, target := range a.targets {
+			targets[name] = target
+		}
+		return targets, true
+	}
+
+	target, ok := a.targets[name]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown endpoint " + name})
+		return nil, false
+	}
+
+	return map[string]*AdminTarget{name: target}, true
+}
+
+// allowMethods writes an error response and returns false unless the
+// request uses one of methods.
+func allowMethods(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// logAction logs a request that changes the state of a janitor, as an
+// audit trail.
+func logAction(ctx context.Context, r *http.Request, action string, targets map[string]*AdminTarget) {
+	names := make([]string, 0, len(targets))
+	for name := range targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	log.WithContext(ctx).
+		WithField("action", action).
+		WithField("endpoints", names).
+		WithField("path", r.URL.Query().Get("path")).
+		WithField("remote_addr", r.RemoteAddr).
+		Info("admin API request")
+}
+
+type adminStatus struct {
+	Paused      bool         `json:"paused"`
+	PausedPaths []string     `json:"paused_paths"`
+	Paths       []string     `json:"paths"`
+	LastResults []PathResult `json:"last_results"`
+}
+
+func (a *Admin) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, "GET") {
+		return
+	}
+	targets, ok := a.selectTargets(w, r)
+	if !ok {
+		return
+	}
+
+	response := make(map[string]adminStatus, len(targets))
+	for name, target := range targets {
+		paused, pausedPaths := target.Janitor.Paused()
+		response[name] = adminStatus{
+			Paused:      paused,
+			PausedPaths: pausedPaths,
+			Paths:       target.Paths(),
+			LastResults: target.Janitor.LastResults(),
+		}
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (a *Admin) handleConfig(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, "GET") {
+		return
+	}
+	targets, ok := a.selectTargets(w, r)
+	if !ok {
+		return
+	}
+
+	response := make(map[string]interface{}, len(targets))
+	for name, target := range targets {
+		opts := target.Janitor.Opts()
+		response[name] = map[string]interface{}{
+			"paths": target.Paths(),
+			"opts":  describeOpts(&opts),
+		}
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// describeOpts returns the options as a map that can be encoded as JSON,
+// with durations written like "1h0m0s" and interfaces left out.
+func describeOpts(opts *JanitorOpts) map[string]interface{} {
+	return describeStruct(reflect.ValueOf(*opts))
+}
+
+func (a *Admin) handlePause(w http.ResponseWriter, r *http.Request) {
+	a.handlePauseResume(w, r, "pause", (*Janitor).Pause, (*Janitor).PausePath)
+}
+
+func (a *Admin) handleResume(w http.ResponseWriter, r *http.Request) {
+	a.handlePauseResume(w, r, "resume", (*Janitor).Resume, (*Janitor).ResumePath)
+}
+
+// handlePauseResume pauses or resumes the selected janitors, or only the
+// path given in the path query parameter.
+func (a *Admin) handlePauseResume(w http.ResponseWriter, r *http.Request, action string, all func(*Janitor), path func(*Janitor, string)) {
+	if !allowMethods(w, r, "POST") {
+		return
+	}
+	targets, ok := a.selectTargets(w, r)
+	if !ok {
+		return
+	}
+
+	logAction(r.Context(), r, action, targets)
+	for _, target := range targets {
+		if r.URL.Query().Get("path") != "" {
+			path(target.Janitor, r.URL.Query().Get("path"))
+		} else {
+			all(target.Janitor)
+		}
+	}
+
+	a.handleStatus(w, withMethod(r, "GET"))
+}
+
+func (a *Admin) handleTrigger(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, "POST") {
+		return
+	}
+	targets, ok := a.selectTargets(w, r)
+	if !ok {
+		return
+	}
+
+	logAction(r.Context(), r, "trigger", targets)
+	for _, target := range targets {
+		target.Scheduler.Trigger()
+	}
+
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "triggered"})
+}
+
+type exemptionRequest struct {
+	VM       string `json:"vm"`
+	Duration string `json:"duration"`
+	Reason   string `json:"reason"`
+}
+
+func (a *Admin) handleExemptions(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, "GET", "POST", "DELETE") {
+		return
+	}
+	targets, ok := a.selectTargets(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case "POST":
+		var req exemptionRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid body: " + err.Error()})
+			return
+		}
+
+		duration, err := time.ParseDuration(req.Duration)
+		if req.VM == "" || err != nil || duration <= 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "an exemption needs a vm and a positive duration"})
+			return
+		}
+
+		logAction(r.Context(), r, "exempt "+req.VM, targets)
+		exemption := Exemption{VM: req.VM, Until: time.Now().Add(duration), Reason: req.Reason}
+		for _, target := range targets {
+			target.Janitor.Exempt(exemption)
+		}
+	case "DELETE":
+		vm := r.URL.Query().Get("vm")
+		if vm == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "missing vm"})
+			return
+		}
+
+		logAction(r.Context(), r, "unexempt "+vm, targets)
+		for _, target := range targets {
+			target.Janitor.Unexempt(vm)
+		}
+	}
+
+	now := time.Now()
+	response := make(map[string][]Exemption, len(targets))
+	for name, target := range targets {
+		response[name] = target.Janitor.Exemptions(now)
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (a *Admin) handleZeroUptime(w http.ResponseWriter, r *http.Request) {
+	if !allowMethods(w, r, "GET") {
+		return
+	}
+	targets, ok := a.selectTargets(w, r)
+	if !ok {
+		return
+	}
+
+	response := make(map[string]map[string]time.Time, len(targets))
+	for name, target := range targets {
+		response[name] = target.Janitor.ZeroUptimeFirstSeen()
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func withMethod(r *http.Request, method string) *http.Request {
+	r2 := *r
+	r2.Method = method
+	return &r2
+}
@@ -0,0 +1,119 @@
+package vspherejanitor_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	vspherejanitor "github.com/travis-ci/vsphere-janitor"
+	"github.com/travis-ci/vsphere-janitor/mock"
+)
+
+func adminRequest(admin *vspherejanitor.Admin, method, url, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+
+	rec := httptest.NewRecorder()
+	admin.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestAdmin(t *testing.T) {
+	vmLister := mock.NewVMLister(map[string][]*mock.VMData{
+		"/a": {
+			{
+				Name:      "old",
+				Uptime:    2 * time.Hour,
+				BootTime:  timePointer(aTime.Add(-2 * time.Hour)),
+				PoweredOn: true,
+			},
+			{
+				Name:      "debugging",
+				Uptime:    2 * time.Hour,
+				BootTime:  timePointer(aTime.Add(-2 * time.Hour)),
+				PoweredOn: true,
+			},
+		},
+		"/b": {
+			{
+				Name:      "old",
+				Uptime:    2 * time.Hour,
+				BootTime:  timePointer(aTime.Add(-2 * time.Hour)),
+				PoweredOn: true,
+			},
+		},
+	})
+
+	janitor := vspherejanitor.NewJanitor(vmLister, &vspherejanitor.JanitorOpts{
+		Cutoff:        time.Hour,
+		Concurrency:   1,
+		RatePerSecond: 100,
+	})
+
+	admin := vspherejanitor.NewAdmin("secret")
+	admin.Register("dc1", &vspherejanitor.AdminTarget{
+		Janitor:   janitor,
+		Scheduler: vspherejanitor.NewScheduler(&vspherejanitor.SchedulerOpts{Interval: time.Hour}),
+		Paths:     func() []string { return []string{"/a", "/b"} },
+	})
+
+	rec := httptest.NewRecorder()
+	admin.ServeHTTP(rec, httptest.NewRequest("POST", "/admin/pause", nil))
+	assertEqual(t, "status without token", http.StatusUnauthorized, rec.Code)
+
+	rec = adminRequest(admin, "GET", "/admin/pause", "")
+	assertEqual(t, "status of GET /admin/pause", http.StatusMethodNotAllowed, rec.Code)
+
+	rec = adminRequest(admin, "POST", "/admin/pause?endpoint=dc2", "")
+	assertEqual(t, "status of pausing unknown endpoint", http.StatusNotFound, rec.Code)
+
+	rec = adminRequest(admin, "POST", "/admin/pause?path=/b", "")
+	assertEqual(t, "status of pausing /b", http.StatusOK, rec.Code)
+
+	rec = adminRequest(admin, "POST", "/admin/exemptions", `{"vm": "debugging", "duration": "1h", "reason": "looking into it"}`)
+	assertEqual(t, "status of exempting", http.StatusOK, rec.Code)
+
+	errs := janitor.CleanupPaths(context.TODO(), []string{"/a", "/b"}, aTime)
+	assertEqual(t, "len(errs)", 0, len(errs))
+	assertEqual(t, `Destroyed("/a", "old")`, true, vmLister.Destroyed("/a", "old"))
+	assertEqual(t, `Destroyed("/a", "debugging")`, false, vmLister.Destroyed("/a", "debugging"))
+	assertEqual(t, `Destroyed("/b", "old")`, false, vmLister.Destroyed("/b", "old"))
+
+	rec = adminRequest(admin, "GET", "/admin/status", "")
+	assertEqual(t, "status of GET /admin/status", http.StatusOK, rec.Code)
+
+	var status map[string]struct {
+		Paused      bool     `json:"paused"`
+		PausedPaths []string `json:"paused_paths"`
+		LastResults []struct {
+			Path      string `json:"path"`
+			Destroyed int    `json:"destroyed"`
+			Paused    bool   `json:"paused"`
+		} `json:"last_results"`
+	}
+	err := json.NewDecoder(rec.Body).Decode(&status)
+	assertOk(t, "decoding status", err)
+	assertEqual(t, "paused", false, status["dc1"].Paused)
+	assertEqual(t, "len(paused paths)", 1, len(status["dc1"].PausedPaths))
+	assertEqual(t, "len(last results)", 2, len(status["dc1"].LastResults))
+	assertEqual(t, "/a destroyed", 1, status["dc1"].LastResults[0].Destroyed)
+	assertEqual(t, "/b paused", true, status["dc1"].LastResults[1].Paused)
+
+	rec = adminRequest(admin, "GET", "/admin/config", "")
+	assertEqual(t, "status of GET /admin/config", http.StatusOK, rec.Code)
+
+	var config map[string]struct {
+		Opts map[string]interface{} `json:"opts"`
+	}
+	err = json.NewDecoder(rec.Body).Decode(&config)
+	assertOk(t, "decoding config", err)
+	assertEqual(t, "cutoff", "1h0m0s", config["dc1"].Opts["Cutoff"])
+
+	rec = adminRequest(admin, "DELETE", "/admin/exemptions?vm=debugging", "")
+	assertEqual(t, "status of unexempting", http.StatusOK, rec.Code)
+	assertEqual(t, "len(exemptions)", 0, len(janitor.Exemptions(time.Now())))
+}
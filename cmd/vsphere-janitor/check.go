@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	metrics "github.com/rcrowley/go-metrics"
+	"github.com/travis-ci/vsphere-janitor"
+	"github.com/travis-ci/vsphere-janitor/log"
+	"github.com/urfave/cli"
+)
+
+var checkCommand = cli.Command{
+	Name:   "check",
+	Usage:  "Validate the configuration, log in to vSphere and resolve every path, without cleaning up",
+	Action: checkAction,
+}
+
+func checkAction(c *cli.Context) error {
+	ctx := context.Background()
+	global := c.Parent()
+	w := c.App.Writer
+
+	configs, _, err := endpointConfigs(global)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("invalid configuration: %v", err), 1)
+	}
+
+	problems := 0
+	for _, ec := range configs {
+		fmt.Fprintf(w, "%s (%s):\n", ec.Name, ec.URL)
+
+		e, err := newEndpoint(ctx, global, ec, nil, metrics.NewRegistry())
+		if err != nil {
+			fmt.Fprintf(w, "  FAIL %v\n", err)
+			problems++
+			continue
+		}
+
+		problems += checkEndpoint(log.ContextWithField(ctx, "endpoint", e.name), c, e)
+	}
+
+	if problems > 0 {
+		return cli.NewExitError(fmt.Sprintf("found %d problems", problems), 1)
+	}
+
+	fmt.Fprintln(w, "OK")
+	return nil
+}
+
+// checkEndpoint logs in to the endpoint and lists the VMs in each of its
+// paths, printing what it finds. It returns the number of problems.
+func checkEndpoint(ctx context.Context, c *cli.Context, e *endpoint) int {
+	w := c.App.Writer
+	defer e.sessions.Logout(ctx)
+
+	err := e.login(ctx)
+	if err != nil {
+		fmt.Fprintf(w, "  FAIL couldn't log in: %v\n", err)
+		return 1
+	}
+	fmt.Fprintln(w, "  ok   logged in")
+
+	problems := 0
+	for _, folder := range e.getPaths() {
+		verdicts, err := e.janitor.Evaluate(ctx, folder, time.Now())
+		if err != nil {
+			fmt.Fprintf(w, "  FAIL %s: %v\n", folder, err)
+			problems++
+			continue
+		}
+
+		actions := make(map[vspherejanitor.Action]int)
+		for _, verdict := range verdicts {
+			actions[verdict.Action]++
+		}
+
+		fmt.Fprintf(w, "  ok   %s: %d VMs, %d to destroy, %d to unregister\n",
+			folder, len(verdicts), actions[vspherejanitor.ActionDestroy], actions[vspherejanitor.ActionUnregister])
+	}
+
+	return problems
+}
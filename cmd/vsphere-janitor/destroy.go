@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/honeycombio/libhoney-go"
+	metrics "github.com/rcrowley/go-metrics"
+	"github.com/travis-ci/vsphere-janitor"
+	"github.com/travis-ci/vsphere-janitor/log"
+	"github.com/urfave/cli"
+)
+
+var destroyCommand = cli.Command{
+	Name:  "destroy",
+	Usage: "Power off and destroy specific VMs right away, regardless of the cleanup policy",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "path",
+			Usage: "Inventory path to look for the VMs in",
+		},
+		cli.StringFlag{
+			Name:  "name-regex",
+			Usage: "Destroy VMs whose whole name matches this regular expression",
+		},
+		cli.StringSliceFlag{
+			Name:  "id",
+			Usage: "Destroy the VM with this UUID (can be repeated)",
+		},
+		cli.StringFlag{
+			Name:  "endpoint",
+			Usage: "Name of the endpoint the path is in, if there are several",
+		},
+		cli.StringFlag{
+			Name:   "operator",
+			Usage:  "Who is destroying the VMs, for the audit trail",
+			EnvVar: "USER",
+		},
+		cli.BoolFlag{
+			Name:  "y, yes",
+			Usage: "Don't ask for confirmation",
+		},
+	},
+	Action: destroyAction,
+}
+
+func destroyAction(c *cli.Context) error {
+	ctx := context.Background()
+	global := c.Parent()
+
+	if c.String("path") == "" {
+		return cli.NewExitError("missing path", 1)
+	}
+	if c.String("name-regex") == "" && len(c.StringSlice("id")) == 0 {
+		return cli.NewExitError("missing name regex or ids of the VMs to destroy", 1)
+	}
+	if c.String("operator") == "" {
+		return cli.NewExitError("missing operator", 1)
+	}
+
+	sel := &vspherejanitor.Selection{IDs: c.StringSlice("id")}
+	if c.String("name-regex") != "" {
+		// anchored, so that "vm-1" doesn't also destroy "vm-10"
+		re, err := regexp.Compile("^(?:" + c.String("name-regex") + ")$")
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf("invalid name regex: %v", err), 1)
+		}
+		sel.NameRegex = re
+	}
+
+	configs, _, err := endpointConfigs(global)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	ec, err := chooseEndpoint(configs, c.String("endpoint"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	registry := metrics.NewRegistry()
+	e, err := newEndpoint(ctx, global, ec, nil, registry)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("couldn't set up endpoint %s: %v", ec.Name, err), 1)
+	}
+
+	ctx = log.ContextWithField(ctx, "endpoint", e.name)
+	defer e.sessions.Logout(ctx)
+
+	vms, err := e.janitor.Select(ctx, c.String("path"), sel)
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("couldn't list VMs in %s: %v", c.String("path"), err), 1)
+	}
+
+	if len(vms) == 0 {
+		fmt.Fprintln(c.App.Writer, "No VMs match.")
+		return nil
+	}
+
+	skipDestroy := e.janitor.Opts().SkipDestroy
+	if skipDestroy {
+		fmt.Fprintf(c.App.Writer, "These %d VMs in %s will be powered off, but not destroyed since --skip-destroy is set:\n\n", len(vms), c.String("path"))
+	} else {
+		fmt.Fprintf(c.App.Writer, "These %d VMs in %s will be powered off and destroyed:\n\n", len(vms), c.String("path"))
+	}
+	err = writePreview(c.App.Writer, vms)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	if !c.Bool("yes") && !confirm(os.Stdin, c.App.Writer, fmt.Sprintf("Destroy these %d VMs?", len(vms))) {
+		fmt.Fprintln(c.App.Writer, "Aborted.")
+		return nil
+	}
+
+	if initHoneycomb(ctx, global) {
+		defer libhoney.Close()
+	}
+
+	errs := e.janitor.Destroy(ctx, c.String("path"), vms, c.String("operator"))
+	reportMetricsOnce(ctx, global, registry)
+
+	if skipDestroy {
+		fmt.Fprintf(c.App.Writer, "Powered off %d of %d VMs, and skipped destroying them since --skip-destroy is set.\n", len(vms)-len(errs), len(vms))
+	} else {
+		fmt.Fprintf(c.App.Writer, "Destroyed %d of %d VMs.\n", len(vms)-len(errs), len(vms))
+	}
+	for vm, err := range errs {
+		fmt.Fprintf(c.App.Writer, "  %s: %v\n", vm, err)
+	}
+
+	if len(errs) > 0 {
+		return cli.NewExitError("some VMs couldn't be destroyed", 1)
+	}
+	return nil
+}
+
+func writePreview(w io.Writer, vms []vspherejanitor.VirtualMachine) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tID\tPOWER STATE\tUPTIME")
+	for _, vm := range vms {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", vm.Name(), vm.ID(), vm.PowerState(), vm.Uptime().Truncate(time.Second))
+	}
+	fmt.Fprintln(tw)
+	return tw.Flush()
+}
+
+// confirm asks a yes or no question, returning true only for an explicit
+// yes.
+func confirm(r io.Reader, w io.Writer, question string) bool {
+	fmt.Fprintf(w, "%s [y/N] ", question)
+
+	answer, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && answer == "" {
+		return false
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	metrics "github.com/rcrowley/go-metrics"
+	"github.com/travis-ci/vsphere-janitor"
+	"github.com/travis-ci/vsphere-janitor/config"
+	"github.com/travis-ci/vsphere-janitor/log"
+	"github.com/travis-ci/vsphere-janitor/vsphere"
+	"github.com/urfave/cli"
+)
+
+// endpointConfigs returns the endpoints in the config file if one is given,
+// and a single endpoint named "default" built from the flags otherwise. It
+// also returns whether the endpoints came from a config file.
+func endpointConfigs(c *cli.Context) ([]*config.Endpoint, bool, error) {
+	if c.String("config") != "" {
+		cfg, err := config.Load(c.String("config"))
+		if err != nil {
+			return nil, false, err
+		}
+		return cfg.Endpoints, true, nil
+	}
+
+	insecure := c.Bool("vsphere-insecure")
+	endpoint := &config.Endpoint{
+		Name:            "default",
+		URL:             c.String("vsphere-url"),
+		Username:        c.String("vsphere-username"),
+		Password:        c.String("vsphere-password"),
+		PasswordFile:    c.String("vsphere-password-file"),
+		PasswordCommand: c.String("vsphere-password-command"),
+		Insecure:        &insecure,
+		CAFile:          c.String("vsphere-ca-file"),
+		Thumbprints:     c.StringSlice("vsphere-thumbprint"),
+		Paths:           c.StringSlice("vsphere-vm-paths"),
+	}
+
+	if len(endpoint.Paths) == 0 {
+		return nil, false, errors.New("missing vsphere vm paths")
+	}
+
+	return []*config.Endpoint{endpoint}, false, nil
+}
+
+// chooseEndpoint returns the endpoint called name, or the only endpoint if
+// name is empty.
+func chooseEndpoint(configs []*config.Endpoint, name string) (*config.Endpoint, error) {
+	if name == "" {
+		if len(configs) > 1 {
+			return nil, errors.New("several endpoints are configured, choose one with --endpoint")
+		}
+		return configs[0], nil
+	}
+
+	for _, ec := range configs {
+		if ec.Name == name {
+			return ec, nil
+		}
+	}
+
+	return nil, errors.Errorf("unknown endpoint %q", name)
+}
+
+// An endpoint is a vCenter with its own session, janitor and scheduler, so
+// its cycles run independently of other endpoints.
+type endpoint struct {
+	name      string
+	sessions  *vsphere.SessionManager
+	client    *vsphere.Client
+	janitor   *vspherejanitor.Janitor
+	scheduler *vspherejanitor.Scheduler
+
+	// config, notifier and registry are kept to rebuild the janitor
+	// options when the configuration is reloaded.
+	config   *config.Endpoint
+	notifier vspherejanitor.Notifier
+	registry metrics.Registry
+
+	pathsMutex sync.Mutex
+	paths      []string
+}
+
+// newEndpoint sets up an endpoint from its configuration, using the flags
+// for everything it doesn't override. Its metrics go to registry.
+func newEndpoint(ctx context.Context, c *cli.Context, ec *config.Endpoint, notifier vspherejanitor.Notifier, registry metrics.Registry) (*endpoint, error) {
+	u, err := url.Parse(ec.URL)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't parse vSphere URL")
+	}
+
+	// the config file's interval is validated with it, but the flag isn't
+	interval := endpointInterval(c, ec)
+	if interval <= 0 && !c.Bool("once") {
+		return nil, errors.Errorf("interval between cleanups must be positive, but was %v, check --cleanup-loop-sleep", interval)
+	}
+
+	tlsOpts := vsphere.TLSOpts{
+		Insecure:    c.Bool("vsphere-insecure"),
+		CAFile:      ec.CAFile,
+		Thumbprints: ec.Thumbprints,
+	}
+	if ec.Insecure != nil {
+		tlsOpts.Insecure = *ec.Insecure
+	}
+	tlsConfig, err := tlsOpts.Config()
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid vSphere TLS configuration")
+	}
+	if tlsConfig.InsecureSkipVerify && len(tlsOpts.Thumbprints) == 0 {
+		log.WithContext(ctx).WithField("endpoint", ec.Name).Warn("not verifying the vSphere certificate, set a CA file or thumbprint instead of insecure")
+	}
+
+	sessions := vsphere.NewSessionManager(&vsphere.SessionManagerOpts{
+		URL:               u,
+		TLS:               tlsOpts,
+		Username:          ec.Username,
+		Password:          passwordSource(ec),
+		KeepAliveInterval: c.Duration("vsphere-keepalive-interval"),
+		Metrics:           registry,
+	})
+
+	vSphereLister, err := vsphere.NewClient(ctx, sessions)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't create vsphere vm lister")
+	}
+
+	janitorOpts, err := endpointJanitorOpts(c, ec, notifier, registry)
+	if err != nil {
+		return nil, err
+	}
+
+	return &endpoint{
+		name:     ec.Name,
+		paths:    ec.Paths,
+		sessions: sessions,
+		client:   vSphereLister,
+		janitor:  vspherejanitor.NewJanitor(vSphereLister, janitorOpts),
+		scheduler: vspherejanitor.NewScheduler(&vspherejanitor.SchedulerOpts{
+			Interval: interval,
+			Jitter:   c.Duration("cycle-jitter"),
+			Timeout:  c.Duration("cycle-timeout"),
+			Metrics:  registry,
+		}),
+		config:   ec,
+		notifier: notifier,
+		registry: registry,
+	}, nil
+}
+
+// endpointJanitorOpts returns the validated janitor options of ec, which are
+// the flags with the policy of ec applied.
+func endpointJanitorOpts(c *cli.Context, ec *config.Endpoint, notifier vspherejanitor.Notifier, registry metrics.Registry) (*vspherejanitor.JanitorOpts, error) {
+	janitorOpts, err := janitorOptsFromFlags(c)
+	if err != nil {
+		return nil, err
+	}
+	ec.Policy.Apply(janitorOpts)
+	janitorOpts.Notifier = notifier
+	janitorOpts.Metrics = registry
+
+	err = janitorOpts.Validate()
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid configuration")
+	}
+
+	return janitorOpts, nil
+}
+
+func endpointInterval(c *cli.Context, ec *config.Endpoint) time.Duration {
+	if ec.Interval != nil {
+		return time.Duration(*ec.Interval)
+	}
+	return c.Duration("cleanup-loop-sleep")
+}
+
+// getPaths returns the paths to clean up, which can change on reload.
+func (e *endpoint) getPaths() []string {
+	e.pathsMutex.Lock()
+	defer e.pathsMutex.Unlock()
+	return e.paths
+}
+
+func (e *endpoint) setPaths(paths []string) {
+	e.pathsMutex.Lock()
+	defer e.pathsMutex.Unlock()
+	e.paths = paths
+}
+
+// login logs in to vSphere. If the certificate can't be verified, the error
+// says how to configure it, since earlier versions didn't verify it at all.
+func (e *endpoint) login(ctx context.Context) error {
+	_, err := e.sessions.Get(ctx)
+	if err != nil && vsphere.IsCertificateError(err) {
+		return errors.Wrap(err, "the vSphere certificate is verified since this version, "+
+			"set --vsphere-ca-file or --vsphere-thumbprint (ca_file or thumbprints in the config file), "+
+			"or --vsphere-insecure (insecure: true) to not verify it as before")
+	}
+	return err
+}
+
+func (e *endpoint) cycle(ctx context.Context) {
+	e.client.StartCycle()
+	errs := e.janitor.CleanupPaths(ctx, e.getPaths(), time.Now())
+	for path, err := range errs {
+		log.WithContext(ctx).WithError(err).WithField("path", path).Error("error cleaning up")
+	}
+}
+
+// passwordSource returns where to get the vSphere password of ec from, or
+// nil to use the one in the URL.
+func passwordSource(ec *config.Endpoint) vsphere.PasswordSource {
+	switch {
+	case ec.PasswordCommand != "":
+		return vsphere.PasswordFromCommand(ec.PasswordCommand)
+	case ec.PasswordFile != "":
+		return vsphere.PasswordFromFile(ec.PasswordFile)
+	case ec.Password != "":
+		return vsphere.StaticPassword(ec.Password)
+	default:
+		return nil
+	}
+}
@@ -8,11 +8,63 @@ import (
 
 var (
 	Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:   "config",
+			Usage:  "YAML file listing the vSphere endpoints to manage, instead of --vsphere-url and --vsphere-vm-paths",
+			EnvVar: "VSPHERE_JANITOR_CONFIG,CONFIG",
+		},
+		cli.DurationFlag{
+			Name:   "config-reload-interval",
+			Value:  30 * time.Second,
+			Usage:  "How often to check the config file for changes to reload, 0 to only reload on SIGHUP",
+			EnvVar: "VSPHERE_JANITOR_CONFIG_RELOAD_INTERVAL,CONFIG_RELOAD_INTERVAL",
+		},
 		cli.StringFlag{
 			Name:   "u, vsphere-url",
 			Usage:  "URL of the vsphere server, including '/sdk' if applicable",
 			EnvVar: "VSPHERE_JANITOR_VSPHERE_URL,VSPHERE_URL",
 		},
+		cli.BoolFlag{
+			Name:   "vsphere-insecure",
+			Usage:  "Don't verify the vSphere certificate unless a CA file or thumbprint is given",
+			EnvVar: "VSPHERE_JANITOR_VSPHERE_INSECURE,VSPHERE_INSECURE",
+		},
+		cli.StringFlag{
+			Name:   "vsphere-ca-file",
+			Usage:  "PEM bundle of CAs to verify the vSphere certificate with",
+			EnvVar: "VSPHERE_JANITOR_VSPHERE_CA_FILE,VSPHERE_CA_FILE",
+		},
+		cli.StringSliceFlag{
+			Name:   "vsphere-thumbprint",
+			Usage:  "SHA-1 or SHA-256 thumbprint to pin the vSphere certificate to (can be repeated)",
+			EnvVar: "VSPHERE_JANITOR_VSPHERE_THUMBPRINT,VSPHERE_THUMBPRINT",
+		},
+		cli.StringFlag{
+			Name:   "vsphere-username",
+			Usage:  "Username to log in to vSphere with, instead of the one in the URL",
+			EnvVar: "VSPHERE_JANITOR_VSPHERE_USERNAME,VSPHERE_USERNAME",
+		},
+		cli.StringFlag{
+			Name:   "vsphere-password",
+			Usage:  "Password to log in to vSphere with, instead of the one in the URL",
+			EnvVar: "VSPHERE_JANITOR_VSPHERE_PASSWORD,VSPHERE_PASSWORD",
+		},
+		cli.StringFlag{
+			Name:   "vsphere-password-file",
+			Usage:  "File to read the vSphere password from on every login",
+			EnvVar: "VSPHERE_JANITOR_VSPHERE_PASSWORD_FILE,VSPHERE_PASSWORD_FILE",
+		},
+		cli.StringFlag{
+			Name:   "vsphere-password-command",
+			Usage:  "Shell command that prints the vSphere password, run on every login",
+			EnvVar: "VSPHERE_JANITOR_VSPHERE_PASSWORD_COMMAND,VSPHERE_PASSWORD_COMMAND",
+		},
+		cli.DurationFlag{
+			Name:   "vsphere-keepalive-interval",
+			Value:  5 * time.Minute,
+			Usage:  "How often to check the vSphere session and log in again if it expired, 0 to disable",
+			EnvVar: "VSPHERE_JANITOR_VSPHERE_KEEPALIVE_INTERVAL,VSPHERE_KEEPALIVE_INTERVAL",
+		},
 		cli.StringSliceFlag{
 			Name:   "p, vsphere-vm-paths",
 			Usage:  "**REQUIRED**: Paths in inventory that contain VMs for cleanup",
@@ -28,7 +80,7 @@ var (
 			Usage:  "Skip over VMs with zero uptime",
 			EnvVar: "VSPHERE_JANITOR_SKIP_ZERO_UPTIME,SKIP_ZERO_UPTIME",
 		},
-		cli.BoolTFlag{
+		cli.BoolFlag{
 			Name:   "B, skip-no-boot-time",
 			Usage:  "Skip over VMs without a boot time",
 			EnvVar: "VSPHERE_JANITOR_SKIP_NO_BOOT_TIME,SKIP_NO_BOOT_TIME",
@@ -45,11 +97,95 @@ var (
 			Usage:  "Max 'zero uptime' cutoff",
 			EnvVar: "VSPHERE_JANITOR_ZERO_UPTIME_CUTOFF,ZERO_UPTIME_CUTOFF",
 		},
+		cli.DurationFlag{
+			Name:   "creation-cutoff",
+			Usage:  "Max age since creation, for VMs that report it; 0 to use uptime only",
+			EnvVar: "VSPHERE_JANITOR_CREATION_CUTOFF,CREATION_CUTOFF",
+		},
+		cli.DurationFlag{
+			Name:   "suspended-cutoff",
+			Usage:  "Max time a VM may stay suspended, defaults to the cutoff",
+			EnvVar: "VSPHERE_JANITOR_SUSPENDED_CUTOFF,SUSPENDED_CUTOFF",
+		},
+		cli.BoolFlag{
+			Name:   "unregister-broken",
+			Usage:  "Unregister VMs that are orphaned, inaccessible or invalid instead of skipping them",
+			EnvVar: "VSPHERE_JANITOR_UNREGISTER_BROKEN,UNREGISTER_BROKEN",
+		},
+		cli.BoolFlag{
+			Name:   "unregister-delete-files",
+			Usage:  "Also delete the files of VMs unregistered by --unregister-broken",
+			EnvVar: "VSPHERE_JANITOR_UNREGISTER_DELETE_FILES,UNREGISTER_DELETE_FILES",
+		},
+		cli.DurationFlag{
+			Name:   "broken-cutoff",
+			Value:  time.Hour,
+			Usage:  "How long a VM must stay orphaned, inaccessible or invalid before --unregister-broken unregisters it",
+			EnvVar: "VSPHERE_JANITOR_BROKEN_CUTOFF,BROKEN_CUTOFF",
+		},
+		cli.StringSliceFlag{
+			Name:   "destroy-schedule-allow",
+			Usage:  "Cron expression of when VMs may be cleaned up, outside of which cycles only observe (can be repeated)",
+			EnvVar: "VSPHERE_JANITOR_DESTROY_SCHEDULE_ALLOW,DESTROY_SCHEDULE_ALLOW",
+		},
+		cli.StringSliceFlag{
+			Name:   "destroy-schedule-blackout",
+			Usage:  "Cron expression of when VMs must not be cleaned up, e.g. during maintenance (can be repeated)",
+			EnvVar: "VSPHERE_JANITOR_DESTROY_SCHEDULE_BLACKOUT,DESTROY_SCHEDULE_BLACKOUT",
+		},
+		cli.StringFlag{
+			Name:   "destroy-schedule-timezone",
+			Value:  "UTC",
+			Usage:  "Time zone of the destroy schedule cron expressions, e.g. 'Europe/Berlin'",
+			EnvVar: "VSPHERE_JANITOR_DESTROY_SCHEDULE_TIMEZONE,DESTROY_SCHEDULE_TIMEZONE",
+		},
+		cli.DurationFlag{
+			Name:   "pressure-cutoff",
+			Usage:  "Cutoff applied while capacity is above a pressure threshold, 0 to never read capacity pressure",
+			EnvVar: "VSPHERE_JANITOR_PRESSURE_CUTOFF,PRESSURE_CUTOFF",
+		},
+		cli.StringFlag{
+			Name:   "pressure-resource-pool",
+			Usage:  "Inventory path of the resource pool to read CPU and memory pressure from, e.g. '/dc/host/cluster/Resources'",
+			EnvVar: "VSPHERE_JANITOR_PRESSURE_RESOURCE_POOL,PRESSURE_RESOURCE_POOL",
+		},
+		cli.StringSliceFlag{
+			Name:   "pressure-datastore",
+			Usage:  "Inventory path of a datastore to read storage pressure from (can be repeated)",
+			EnvVar: "VSPHERE_JANITOR_PRESSURE_DATASTORE,PRESSURE_DATASTORE",
+		},
+		cli.Float64Flag{
+			Name:   "pressure-cpu-threshold",
+			Usage:  "CPU utilization of the resource pool, e.g. 0.9, above which to clean up aggressively; 0 to ignore CPU",
+			EnvVar: "VSPHERE_JANITOR_PRESSURE_CPU_THRESHOLD,PRESSURE_CPU_THRESHOLD",
+		},
+		cli.Float64Flag{
+			Name:   "pressure-memory-threshold",
+			Usage:  "Memory utilization of the resource pool above which to clean up aggressively; 0 to ignore memory",
+			EnvVar: "VSPHERE_JANITOR_PRESSURE_MEMORY_THRESHOLD,PRESSURE_MEMORY_THRESHOLD",
+		},
+		cli.Float64Flag{
+			Name:   "pressure-storage-threshold",
+			Usage:  "Utilization of the fullest datastore above which to clean up aggressively; 0 to ignore storage",
+			EnvVar: "VSPHERE_JANITOR_PRESSURE_STORAGE_THRESHOLD,PRESSURE_STORAGE_THRESHOLD",
+		},
 		cli.IntFlag{
 			Name:   "c, concurrency",
-			Usage:  "Concurrent cleanup goroutine count",
+			Value:  1,
+			Usage:  "Concurrent cleanup goroutine count per path",
 			EnvVar: "VSPHERE_JANITOR_CONCURRENCY,CONCURRENCY",
 		},
+		cli.IntFlag{
+			Name:   "path-concurrency",
+			Value:  1,
+			Usage:  "Number of paths cleaned up at the same time",
+			EnvVar: "VSPHERE_JANITOR_PATH_CONCURRENCY,PATH_CONCURRENCY",
+		},
+		cli.IntFlag{
+			Name:   "global-concurrency",
+			Usage:  "Concurrent cleanup goroutine count across all paths, 0 for no limit",
+			EnvVar: "VSPHERE_JANITOR_GLOBAL_CONCURRENCY,GLOBAL_CONCURRENCY",
+		},
 		cli.BoolFlag{
 			Name:   "O, once",
 			Usage:  "Only run one cleanup",
@@ -58,15 +194,145 @@ var (
 		cli.DurationFlag{
 			Name:   "s, cleanup-loop-sleep",
 			Value:  1 * time.Minute,
-			Usage:  "Sleep interval between cleaning up all paths",
+			Usage:  "Interval between the starts of cleanups of all paths",
 			EnvVar: "VSPHERE_JANITOR_CLEANUP_LOOP_SLEEP,CLEANUP_LOOP_SLEEP",
 		},
+		cli.DurationFlag{
+			Name:   "cycle-jitter",
+			Usage:  "Max random delay added before every cleanup of all paths",
+			EnvVar: "VSPHERE_JANITOR_CYCLE_JITTER,CYCLE_JITTER",
+		},
+		cli.DurationFlag{
+			Name:   "cycle-timeout",
+			Usage:  "Max duration of a cleanup of all paths, 0 for no timeout",
+			EnvVar: "VSPHERE_JANITOR_CYCLE_TIMEOUT,CYCLE_TIMEOUT",
+		},
 		cli.IntFlag{
 			Name:   "R, rate-per-second",
 			Value:  5,
-			Usage:  "Rate limit max vms handled per second",
+			Usage:  "Rate limit of vSphere API calls per second",
 			EnvVar: "VSPHERE_JANITOR_RATE_PER_SECOND,RATE_PER_SECOND",
 		},
+		cli.IntFlag{
+			Name:   "rate-burst",
+			Value:  5,
+			Usage:  "Max burst of vSphere API calls above the rate limit",
+			EnvVar: "VSPHERE_JANITOR_RATE_BURST,RATE_BURST",
+		},
+		cli.IntFlag{
+			Name:   "max-destroys-per-cycle",
+			Usage:  "Max VMs destroyed per path in one cleanup, 0 for no limit",
+			EnvVar: "VSPHERE_JANITOR_MAX_DESTROYS_PER_CYCLE,MAX_DESTROYS_PER_CYCLE",
+		},
+		cli.StringFlag{
+			Name:   "cleanup-order",
+			Value:  "oldest",
+			Usage:  "Order to clean up VMs in: oldest, powered-on, largest or listed",
+			EnvVar: "VSPHERE_JANITOR_CLEANUP_ORDER,CLEANUP_ORDER",
+		},
+		cli.BoolFlag{
+			Name:   "adaptive-throttle",
+			Usage:  "Back off concurrency and rate when vCenter gets slow or faulty",
+			EnvVar: "VSPHERE_JANITOR_ADAPTIVE_THROTTLE,ADAPTIVE_THROTTLE",
+		},
+		cli.DurationFlag{
+			Name:   "adaptive-throttle-latency-threshold",
+			Value:  time.Minute,
+			Usage:  "Task latency above which vCenter is considered overloaded",
+			EnvVar: "VSPHERE_JANITOR_ADAPTIVE_THROTTLE_LATENCY_THRESHOLD,ADAPTIVE_THROTTLE_LATENCY_THRESHOLD",
+		},
+		cli.Float64Flag{
+			Name:   "adaptive-throttle-decrease-factor",
+			Value:  0.5,
+			Usage:  "Factor applied to concurrency and rate when vCenter is overloaded",
+			EnvVar: "VSPHERE_JANITOR_ADAPTIVE_THROTTLE_DECREASE_FACTOR,ADAPTIVE_THROTTLE_DECREASE_FACTOR",
+		},
+		cli.IntFlag{
+			Name:   "adaptive-throttle-increase-window",
+			Value:  10,
+			Usage:  "Healthy tasks in a row after which concurrency and rate are increased",
+			EnvVar: "VSPHERE_JANITOR_ADAPTIVE_THROTTLE_INCREASE_WINDOW,ADAPTIVE_THROTTLE_INCREASE_WINDOW",
+		},
+		cli.DurationFlag{
+			Name:   "adaptive-throttle-cooldown",
+			Value:  30 * time.Second,
+			Usage:  "Min time between two decreases of concurrency and rate",
+			EnvVar: "VSPHERE_JANITOR_ADAPTIVE_THROTTLE_COOLDOWN,ADAPTIVE_THROTTLE_COOLDOWN",
+		},
+		cli.IntFlag{
+			Name:   "max-retries",
+			Value:  3,
+			Usage:  "Max retries of a power off or destroy that failed with a transient error",
+			EnvVar: "VSPHERE_JANITOR_MAX_RETRIES,MAX_RETRIES",
+		},
+		cli.DurationFlag{
+			Name:   "retry-backoff",
+			Value:  2 * time.Second,
+			Usage:  "Initial backoff between retries of a power off or destroy",
+			EnvVar: "VSPHERE_JANITOR_RETRY_BACKOFF,RETRY_BACKOFF",
+		},
+		cli.DurationFlag{
+			Name:   "max-retry-backoff",
+			Value:  30 * time.Second,
+			Usage:  "Max backoff between retries of a power off or destroy",
+			EnvVar: "VSPHERE_JANITOR_MAX_RETRY_BACKOFF,MAX_RETRY_BACKOFF",
+		},
+		cli.IntFlag{
+			Name:   "max-failed-attempts",
+			Usage:  "Give up on a VM after this many consecutive failed cleanups, 0 to never give up",
+			EnvVar: "VSPHERE_JANITOR_MAX_FAILED_ATTEMPTS,MAX_FAILED_ATTEMPTS",
+		},
+		cli.DurationFlag{
+			Name:   "power-off-timeout",
+			Value:  5 * time.Minute,
+			Usage:  "Max time to wait for a single power off task, 0 for no timeout",
+			EnvVar: "VSPHERE_JANITOR_POWER_OFF_TIMEOUT,POWER_OFF_TIMEOUT",
+		},
+		cli.DurationFlag{
+			Name:   "destroy-timeout",
+			Value:  5 * time.Minute,
+			Usage:  "Max time to wait for a single destroy task, 0 for no timeout",
+			EnvVar: "VSPHERE_JANITOR_DESTROY_TIMEOUT,DESTROY_TIMEOUT",
+		},
+		cli.StringFlag{
+			Name:   "notify-webhook-url",
+			Usage:  "URL to POST notifications about unusual cleanup outcomes to",
+			EnvVar: "VSPHERE_JANITOR_NOTIFY_WEBHOOK_URL,NOTIFY_WEBHOOK_URL",
+		},
+		cli.StringSliceFlag{
+			Name:   "notify-webhook-header",
+			Usage:  "Header to send with notification webhook requests, as 'Name: value'",
+			EnvVar: "VSPHERE_JANITOR_NOTIFY_WEBHOOK_HEADERS,NOTIFY_WEBHOOK_HEADERS",
+		},
+		cli.StringFlag{
+			Name:   "notify-webhook-template",
+			Usage:  "Path to a Go text/template file used to render notification webhook bodies",
+			EnvVar: "VSPHERE_JANITOR_NOTIFY_WEBHOOK_TEMPLATE,NOTIFY_WEBHOOK_TEMPLATE",
+		},
+		cli.IntFlag{
+			Name:   "notify-webhook-max-retries",
+			Value:  3,
+			Usage:  "Max retries for failed notification webhook requests",
+			EnvVar: "VSPHERE_JANITOR_NOTIFY_WEBHOOK_MAX_RETRIES,NOTIFY_WEBHOOK_MAX_RETRIES",
+		},
+		cli.DurationFlag{
+			Name:   "notify-webhook-retry-backoff",
+			Value:  time.Second,
+			Usage:  "Initial backoff between notification webhook retries",
+			EnvVar: "VSPHERE_JANITOR_NOTIFY_WEBHOOK_RETRY_BACKOFF,NOTIFY_WEBHOOK_RETRY_BACKOFF",
+		},
+		cli.DurationFlag{
+			Name:   "notify-webhook-timeout",
+			Value:  30 * time.Second,
+			Usage:  "Timeout of each notification webhook request",
+			EnvVar: "VSPHERE_JANITOR_NOTIFY_WEBHOOK_TIMEOUT,NOTIFY_WEBHOOK_TIMEOUT",
+		},
+		cli.IntFlag{
+			Name:   "notify-failure-threshold",
+			Value:  3,
+			Usage:  "Consecutive failures to destroy a VM after which a notification is sent",
+			EnvVar: "VSPHERE_JANITOR_NOTIFY_FAILURE_THRESHOLD,NOTIFY_FAILURE_THRESHOLD",
+		},
 		cli.StringFlag{
 			Name:   "librato-email",
 			Usage:  "Librato metrics account email",
@@ -102,5 +368,20 @@ var (
 			Usage:  "Port to set up net/http/pprof on",
 			EnvVar: "VSPHERE_JANITOR_PPROF_PORT,PPROF_PORT",
 		},
+		cli.StringFlag{
+			Name:   "http-addr",
+			Usage:  "Address to serve the /healthz endpoint and admin API on, e.g. ':8080'",
+			EnvVar: "VSPHERE_JANITOR_HTTP_ADDR,HTTP_ADDR",
+		},
+		cli.StringFlag{
+			Name:   "admin-token",
+			Usage:  "Bearer token for the admin API under /admin/, which is disabled without one",
+			EnvVar: "VSPHERE_JANITOR_ADMIN_TOKEN,ADMIN_TOKEN",
+		},
+		cli.StringFlag{
+			Name:   "admin-token-file",
+			Usage:  "File to read the admin API bearer token from",
+			EnvVar: "VSPHERE_JANITOR_ADMIN_TOKEN_FILE,ADMIN_TOKEN_FILE",
+		},
 	}
 )
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	metrics "github.com/rcrowley/go-metrics"
+	"github.com/travis-ci/vsphere-janitor"
+	"github.com/travis-ci/vsphere-janitor/log"
+	"github.com/urfave/cli"
+)
+
+var inspectCommand = cli.Command{
+	Name:      "inspect",
+	Usage:     "Show the vSphere properties of a VM and how the current policy evaluates them",
+	ArgsUsage: "<path> <name-or-uuid>",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "endpoint",
+			Usage: "Name of the endpoint the path is in, if there are several",
+		},
+	},
+	Action: inspectAction,
+}
+
+func inspectAction(c *cli.Context) error {
+	ctx := context.Background()
+	global := c.Parent()
+
+	if c.NArg() != 2 {
+		return cli.NewExitError("expected a path and the name or UUID of a VM", 1)
+	}
+	path, nameOrID := c.Args().Get(0), c.Args().Get(1)
+
+	configs, _, err := endpointConfigs(global)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	ec, err := chooseEndpoint(configs, c.String("endpoint"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	e, err := newEndpoint(ctx, global, ec, nil, metrics.NewRegistry())
+	if err != nil {
+		return cli.NewExitError(fmt.Sprintf("couldn't set up endpoint %s: %v", ec.Name, err), 1)
+	}
+
+	ctx = log.ContextWithField(ctx, "endpoint", e.name)
+	defer e.sessions.Logout(ctx)
+
+	verdict, err := e.janitor.Inspect(ctx, path, nameOrID, time.Now())
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	err = writeInspection(c.App.Writer, verdict)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	return nil
+}
+
+func writeInspection(w io.Writer, verdict *vspherejanitor.Verdict) error {
+	vm := verdict.VM
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+
+	if raw, ok := vm.(vspherejanitor.RawPropertiesVM); ok {
+		fmt.Fprintln(tw, "vSphere properties:")
+
+		props := raw.RawProperties()
+		names := make([]string, 0, len(props))
+		for name := range props {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			fmt.Fprintf(tw, "  %s\t%s\n", name, formatValue(props[name]))
+		}
+		fmt.Fprintln(tw)
+	}
+
+	fmt.Fprintln(tw, "Values:")
+	fmt.Fprintf(tw, "  Name\t%s\n", vm.Name())
+	fmt.Fprintf(tw, "  ID\t%s\n", vm.ID())
+	fmt.Fprintf(tw, "  Uptime\t%v\n", vm.Uptime())
+	fmt.Fprintf(tw, "  BootTime\t%s\n", formatValue(vm.BootTime()))
+	fmt.Fprintf(tw, "  PoweredOn\t%v\n", vm.PoweredOn())
+	fmt.Fprintf(tw, "  PowerState\t%s\n", vm.PowerState())
+	fmt.Fprintf(tw, "  SuspendTime\t%s\n", formatValue(vm.SuspendTime()))
+	fmt.Fprintf(tw, "  CreatedAt\t%s\n", formatValue(vm.CreatedAt()))
+	fmt.Fprintf(tw, "  ConnectionState\t%s\n", vm.ConnectionState())
+	fmt.Fprintf(tw, "  Template\t%v\n", vm.Template())
+	fmt.Fprintf(tw, "  LinkedCloneParent\t%v\n", vm.LinkedCloneParent())
+	fmt.Fprintf(tw, "  ZeroUptimeFirstSeen\t%s\n", formatValue(verdict.ZeroUptimeFirstSeen))
+	fmt.Fprintf(tw, "  BrokenFirstSeen\t%s\n", formatValue(verdict.BrokenFirstSeen))
+	fmt.Fprintln(tw)
+
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, "Policy:")
+	for i, step := range verdict.Trace {
+		fmt.Fprintf(w, "  %d. %s\n", i+1, step)
+	}
+	fmt.Fprintf(w, "\nVerdict: %s (%s)\n", verdict.Action, verdict.Reason)
+
+	return nil
+}
+
+// formatValue formats a property value, showing what pointers point to.
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case *time.Time:
+		return formatTime(v)
+	case []string:
+		if len(v) == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%v", v)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	metrics "github.com/rcrowley/go-metrics"
+	"github.com/travis-ci/vsphere-janitor"
+	"github.com/travis-ci/vsphere-janitor/log"
+	"github.com/urfave/cli"
+)
+
+var listCommand = cli.Command{
+	Name:  "list",
+	Usage: "List the VMs in the configured paths and what the current policy would do with them",
+	Flags: []cli.Flag{
+		cli.StringFlag{
+			Name:  "format",
+			Value: "table",
+			Usage: "Output format: table, json or csv",
+		},
+		cli.StringFlag{
+			Name:  "sort",
+			Value: "name",
+			Usage: "Column to sort by: name, path, power-state, uptime, boot-time or action",
+		},
+		cli.StringFlag{
+			Name:  "name",
+			Usage: "Only list VMs whose name matches this glob pattern",
+		},
+		cli.StringSliceFlag{
+			Name:  "action",
+			Usage: "Only list VMs the policy would take this action on: skip, destroy or unregister (can be repeated)",
+		},
+		cli.StringFlag{
+			Name:  "power-state",
+			Usage: "Only list VMs in this power state: on, off, suspended or unknown",
+		},
+		cli.StringFlag{
+			Name:  "endpoint",
+			Usage: "Only list VMs of the endpoint with this name",
+		},
+		cli.StringFlag{
+			Name:  "admin-url",
+			Usage: "URL of a running janitor's admin API, e.g. 'http://localhost:8080', to take when it first saw VMs with zero uptime from",
+		},
+	},
+	Action: listAction,
+}
+
+// A vmRow is a VM and its verdict, as printed by the list command.
+type vmRow struct {
+	Endpoint            string     `json:"endpoint"`
+	Path                string     `json:"path"`
+	Name                string     `json:"name"`
+	ID                  string     `json:"id"`
+	PowerState          string     `json:"power_state"`
+	UptimeSeconds       int64      `json:"uptime_seconds"`
+	BootTime            *time.Time `json:"boot_time"`
+	ZeroUptimeFirstSeen *time.Time `json:"zero_uptime_first_seen"`
+	Action              string     `json:"action"`
+	Reason              string     `json:"reason"`
+}
+
+func newVMRow(endpoint, folder string, verdict *vspherejanitor.Verdict) *vmRow {
+	vm := verdict.VM
+	return &vmRow{
+		Endpoint:            endpoint,
+		Path:                folder,
+		Name:                vm.Name(),
+		ID:                  vm.ID(),
+		PowerState:          string(vm.PowerState()),
+		UptimeSeconds:       int64(vm.Uptime() / time.Second),
+		BootTime:            vm.BootTime(),
+		ZeroUptimeFirstSeen: verdict.ZeroUptimeFirstSeen,
+		Action:              string(verdict.Action),
+		Reason:              verdict.Reason,
+	}
+}
+
+var listColumns = []struct {
+	name  string
+	value func(*vmRow) string
+}{
+	{"ENDPOINT", func(r *vmRow) string { return r.Endpoint }},
+	{"PATH", func(r *vmRow) string { return r.Path }},
+	{"NAME", func(r *vmRow) string { return r.Name }},
+	{"ID", func(r *vmRow) string { return r.ID }},
+	{"POWER STATE", func(r *vmRow) string { return r.PowerState }},
+	{"UPTIME", func(r *vmRow) string { return (time.Duration(r.UptimeSeconds) * time.Second).String() }},
+	{"BOOT TIME", func(r *vmRow) string { return formatTime(r.BootTime) }},
+	{"ZERO UPTIME FIRST SEEN", func(r *vmRow) string { return formatTime(r.ZeroUptimeFirstSeen) }},
+	{"ACTION", func(r *vmRow) string { return r.Action }},
+	{"REASON", func(r *vmRow) string { return r.Reason }},
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+var listSorts = map[string]func(a, b *vmRow) bool{
+	"name":        func(a, b *vmRow) bool { return a.Name < b.Name },
+	"path":        func(a, b *vmRow) bool { return a.Endpoint+a.Path < b.Endpoint+b.Path },
+	"power-state": func(a, b *vmRow) bool { return a.PowerState < b.PowerState },
+	"uptime":      func(a, b *vmRow) bool { return a.UptimeSeconds < b.UptimeSeconds },
+	"action":      func(a, b *vmRow) bool { return a.Action < b.Action },
+	"boot-time": func(a, b *vmRow) bool {
+		if a.BootTime == nil || b.BootTime == nil {
+			return a.BootTime == nil && b.BootTime != nil
+		}
+		return a.BootTime.Before(*b.BootTime)
+	},
+}
+
+// listFilter decides which rows the list command prints.
+type listFilter struct {
+	name       string
+	actions    []string
+	powerState string
+}
+
+func (f *listFilter) match(row *vmRow) bool {
+	if f.name != "" {
+		if ok, _ := path.Match(f.name, row.Name); !ok {
+			return false
+		}
+	}
+
+	if f.powerState != "" && row.PowerState != f.powerState {
+		return false
+	}
+
+	if len(f.actions) == 0 {
+		return true
+	}
+	for _, action := range f.actions {
+		if row.Action == action {
+			return true
+		}
+	}
+	return false
+}
+
+func listAction(c *cli.Context) error {
+	ctx := context.Background()
+	global := c.Parent()
+
+	switch c.String("format") {
+	case "table", "json", "csv":
+	default:
+		return cli.NewExitError(fmt.Sprintf("unknown format %q, expected table, json or csv", c.String("format")), 1)
+	}
+
+	less, ok := listSorts[c.String("sort")]
+	if !ok {
+		return cli.NewExitError(fmt.Sprintf("unknown sort column %q", c.String("sort")), 1)
+	}
+
+	filter := &listFilter{
+		name:       c.String("name"),
+		actions:    c.StringSlice("action"),
+		powerState: c.String("power-state"),
+	}
+	if _, err := path.Match(filter.name, ""); err != nil {
+		return cli.NewExitError(fmt.Sprintf("invalid name pattern %q: %v", filter.name, err), 1)
+	}
+
+	configs, _, err := endpointConfigs(global)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	var zeroUptimeFirstSeen map[string]map[string]time.Time
+	if c.String("admin-url") != "" {
+		token, err := readAdminToken(global)
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf("couldn't read admin token: %v", err), 1)
+		}
+
+		zeroUptimeFirstSeen, err = fetchZeroUptimeFirstSeen(ctx, c.String("admin-url"), token)
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf("couldn't get zero uptime VMs from the running janitor: %v", err), 1)
+		}
+	} else {
+		fmt.Fprintln(os.Stderr, "Note: VMs with zero uptime are listed as if they were seen for the first time, since only a running janitor tracks them. Pass --admin-url to use its tracking.")
+	}
+
+	rows := []*vmRow{}
+	for _, ec := range configs {
+		if c.String("endpoint") != "" && ec.Name != c.String("endpoint") {
+			continue
+		}
+
+		e, err := newEndpoint(ctx, global, ec, nil, metrics.NewRegistry())
+		if err != nil {
+			return cli.NewExitError(fmt.Sprintf("couldn't set up endpoint %s: %v", ec.Name, err), 1)
+		}
+
+		e.janitor.RememberZeroUptimeFirstSeen(zeroUptimeFirstSeen[e.name])
+
+		ctx := log.ContextWithField(ctx, "endpoint", e.name)
+		for _, folder := range e.getPaths() {
+			verdicts, err := e.janitor.Evaluate(ctx, folder, time.Now())
+			if err != nil {
+				e.sessions.Logout(ctx)
+				return cli.NewExitError(fmt.Sprintf("couldn't list VMs in %s: %v", folder, err), 1)
+			}
+
+			for _, verdict := range verdicts {
+				row := newVMRow(e.name, folder, verdict)
+				if filter.match(row) {
+					rows = append(rows, row)
+				}
+			}
+		}
+		e.sessions.Logout(ctx)
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool { return less(rows[i], rows[j]) })
+
+	err = writeRows(c.App.Writer, c.String("format"), rows)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	return nil
+}
+
+// fetchZeroUptimeFirstSeen gets when the janitor with the admin API at
+// adminURL first saw VMs with zero uptime, by endpoint and VM ID.
+func fetchZeroUptimeFirstSeen(ctx context.Context, adminURL, token string) (map[string]map[string]time.Time, error) {
+	req, err := http.NewRequest("GET", strings.TrimSuffix(adminURL, "/")+"/admin/zero-uptime", nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("admin API returned %s", resp.Status)
+	}
+
+	firstSeen := map[string]map[string]time.Time{}
+	err = json.NewDecoder(resp.Body).Decode(&firstSeen)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't decode admin API response: %v", err)
+	}
+
+	return firstSeen, nil
+}
+
+func writeRows(w io.Writer, format string, rows []*vmRow) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rows)
+	case "csv":
+		cw := csv.NewWriter(w)
+		record := make([]string, len(listColumns))
+		for i, column := range listColumns {
+			record[i] = strings.ToLower(strings.Replace(column.name, " ", "_", -1))
+		}
+		cw.Write(record)
+		for _, row := range rows {
+			for i, column := range listColumns {
+				record[i] = column.value(row)
+			}
+			cw.Write(record)
+		}
+		cw.Flush()
+		return cw.Error()
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+		values := make([]string, len(listColumns))
+		for i, column := range listColumns {
+			values[i] = column.name
+		}
+		fmt.Fprintln(tw, strings.Join(values, "\t"))
+		for _, row := range rows {
+			for i, column := range listColumns {
+				values[i] = column.value(row)
+			}
+			fmt.Fprintln(tw, strings.Join(values, "\t"))
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown format %q, expected table, json or csv", format)
+	}
+}
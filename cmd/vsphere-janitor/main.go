@@ -3,10 +3,12 @@ package main
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
 	"net/http"
-	"net/url"
 	"os"
 	"runtime"
+	"strings"
+	"sync"
 	"time"
 
 	_ "net/http/pprof"
@@ -14,9 +16,11 @@ import (
 	"github.com/Sirupsen/logrus"
 	"github.com/honeycombio/libhoney-go"
 	librato "github.com/mihasya/go-metrics-librato"
+	"github.com/pkg/errors"
 	metrics "github.com/rcrowley/go-metrics"
 	"github.com/travis-ci/vsphere-janitor"
 	"github.com/travis-ci/vsphere-janitor/log"
+	"github.com/travis-ci/vsphere-janitor/notify"
 	"github.com/travis-ci/vsphere-janitor/vsphere"
 	"github.com/urfave/cli"
 )
@@ -50,6 +54,12 @@ func main() {
 
 	app.Flags = Flags
 	app.Action = mainAction
+	app.Commands = []cli.Command{
+		checkCommand,
+		listCommand,
+		destroyCommand,
+		inspectCommand,
+	}
 
 	app.Run(os.Args)
 }
@@ -69,32 +79,73 @@ func mainAction(c *cli.Context) error {
 		}()
 	}
 
-	u, err := url.Parse(c.String("vsphere-url"))
+	configs, fromConfigFile, err := endpointConfigs(c)
 	if err != nil {
-		log.WithContext(ctx).WithError(err).Fatal("couldn't parse vSphere URL")
+		log.WithContext(ctx).WithError(err).Fatal("couldn't configure endpoints")
 	}
 
-	paths := c.StringSlice("vsphere-vm-paths")
-	if len(paths) == 0 {
-		log.WithContext(ctx).Fatal("missing vsphere vm paths")
+	var notifier vspherejanitor.Notifier
+	if c.String("notify-webhook-url") != "" {
+		log.WithContext(ctx).Info("configuring webhook notifications")
+
+		webhook, err := newWebhook(c)
+		if err != nil {
+			log.WithContext(ctx).WithError(err).Fatal("couldn't configure notification webhook")
+		}
+		notifier = webhook
 	}
 
-	cleanupLoopSleep := c.Duration("cleanup-loop-sleep")
+	health := vspherejanitor.NewHealth()
 
-	vSphereLister, err := vsphere.NewClient(ctx, u, true)
+	adminToken, err := readAdminToken(c)
 	if err != nil {
-		log.WithContext(ctx).WithError(err).Fatal("couldn't create vsphere vm lister")
+		log.WithContext(ctx).WithError(err).Fatal("couldn't read admin API token")
 	}
+	admin := vspherejanitor.NewAdmin(adminToken)
+	if adminToken != "" && c.String("http-addr") == "" {
+		log.WithContext(ctx).Warn("admin API token given, but no HTTP address to serve the admin API on")
+	}
+	endpoints := make([]*endpoint, 0, len(configs))
 
-	janitor := vspherejanitor.NewJanitor(vSphereLister, &vspherejanitor.JanitorOpts{
-		Cutoff:           c.Duration("cutoff"),
-		ZeroUptimeCutoff: c.Duration("zero-uptime-cutoff"),
-		SkipDestroy:      c.Bool("skip-destroy"),
-		Concurrency:      c.Int("concurrency"),
-		RatePerSecond:    c.Int("rate-per-second"),
-	})
+	for _, ec := range configs {
+		// with a config file every endpoint reports its own metrics, while
+		// the single endpoint from the flags keeps the unprefixed names
+		registry := metrics.DefaultRegistry
+		if fromConfigFile {
+			registry = metrics.NewPrefixedChildRegistry(metrics.DefaultRegistry, "endpoint."+ec.Name+".")
+		}
+
+		e, err := newEndpoint(ctx, c, ec, notifier, registry)
+		if err != nil {
+			log.WithContext(ctx).WithError(err).WithField("endpoint", ec.Name).Fatal("couldn't set up endpoint")
+		}
+
+		health.Register("vsphere_session."+e.name, e.sessions.Healthy)
+		admin.Register(e.name, &vspherejanitor.AdminTarget{
+			Janitor:   e.janitor,
+			Scheduler: e.scheduler,
+			Paths:     e.getPaths,
+		})
+		endpoints = append(endpoints, e)
+	}
+
+	if c.String("http-addr") != "" {
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/healthz", health)
+			if adminToken != "" {
+				mux.Handle("/admin/", admin)
+			}
+
+			log.WithContext(ctx).WithField("addr", c.String("http-addr")).Info("serving health endpoint")
+			err := http.ListenAndServe(c.String("http-addr"), mux)
+			if err != nil {
+				log.WithContext(ctx).WithError(err).Error("health endpoint server failed")
+			}
+		}()
+	}
 
-	if c.String("librato-email") != "" && c.String("librato-token") != "" && c.String("librato-source") != "" {
+	if libratoConfigured(c) {
 		log.WithContext(ctx).Info("starting librato metrics reporter")
 
 		go librato.Librato(metrics.DefaultRegistry, time.Minute,
@@ -107,36 +158,211 @@ func mainAction(c *cli.Context) error {
 		}
 	}
 
-	if c.String("honeycomb-write-key") != "" && c.String("honeycomb-dataset") != "" {
-		log.WithContext(ctx).Info("configuring honeycomb reporting")
-
-		libhoney.Init(libhoney.Config{
-			WriteKey: c.String("honeycomb-write-key"),
-			Dataset:  c.String("honeycomb-dataset"),
-		})
+	if initHoneycomb(ctx, c) {
 		defer libhoney.Close()
+	}
 
-		libhoney.AddDynamicField("meta.goroutines", func() interface{} { return runtime.NumGoroutine() })
-		libhoney.AddField("app.version", c.App.Version)
-		libhoney.AddField("service_name", c.String("librato-source"))
+	if fromConfigFile && !c.Bool("once") {
+		go newReloader(c, endpoints).run(ctx, c.Duration("config-reload-interval"))
 	}
 
-	for {
-		for _, path := range paths {
-			err := janitor.Cleanup(ctx, path, time.Now())
-			if err != nil {
-				log.WithContext(ctx).WithError(err).Error("error cleaning up")
+	wg := sync.WaitGroup{}
+	for _, e := range endpoints {
+		wg.Add(1)
+		go func(e *endpoint) {
+			defer wg.Done()
+
+			ctx := log.ContextWithField(ctx, "endpoint", e.name)
+			go e.sessions.KeepAlive(ctx)
+			defer e.sessions.Logout(ctx)
+
+			// a certificate that can't be verified won't fix itself, so
+			// point at the options right away rather than every cycle
+			err := e.login(ctx)
+			if err != nil && vsphere.IsCertificateError(err) {
+				log.WithContext(ctx).WithError(err).Error("couldn't verify the vSphere certificate")
 			}
+
+			if c.Bool("once") {
+				e.scheduler.RunOnce(ctx, e.cycle)
+				return
+			}
+
+			log.WithContext(ctx).WithField("paths", e.getPaths()).Info("starting cleanup loop")
+			e.scheduler.Run(ctx, e.cycle)
+		}(e)
+	}
+	wg.Wait()
+
+	if c.Bool("once") {
+		log.WithContext(ctx).Info("finishing after one run")
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	for _, e := range endpoints {
+		err := e.janitor.WaitForNotifications(waitCtx)
+		if err != nil {
+			log.WithContext(ctx).WithError(err).WithField("endpoint", e.name).Warn("gave up waiting for notifications to be sent")
 		}
+	}
 
-		if c.Bool("once") {
-			log.WithContext(ctx).Info("finishing after one run")
-			break
+	return nil
+}
+
+// readAdminToken returns the admin API token from the flags, or an empty
+// string if the admin API is disabled.
+func readAdminToken(c *cli.Context) (string, error) {
+	if c.String("admin-token-file") == "" {
+		return c.String("admin-token"), nil
+	}
+
+	b, err := ioutil.ReadFile(c.String("admin-token-file"))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(b)), nil
+}
+
+func libratoConfigured(c *cli.Context) bool {
+	return c.String("librato-email") != "" && c.String("librato-token") != "" && c.String("librato-source") != ""
+}
+
+// reportMetricsOnce sends the metrics in registry to Librato right away, if
+// it is configured, for commands that exit before a periodic reporter would
+// get to them.
+func reportMetricsOnce(ctx context.Context, c *cli.Context, registry metrics.Registry) {
+	if !libratoConfigured(c) {
+		return
+	}
+
+	reporter := librato.NewReporter(registry, time.Minute,
+		c.String("librato-email"), c.String("librato-token"), c.String("librato-source"),
+		[]float64{0.95}, time.Millisecond)
+
+	batch, err := reporter.BuildRequest(time.Now(), registry)
+	if err == nil {
+		client := &librato.LibratoClient{Email: c.String("librato-email"), Token: c.String("librato-token")}
+		err = client.PostMetrics(batch)
+	}
+	if err != nil {
+		log.WithContext(ctx).WithError(err).Warn("couldn't report metrics to librato")
+	}
+}
+
+// initHoneycomb configures sending events to Honeycomb if it is enabled,
+// returning whether it is. The caller must close libhoney when it is.
+func initHoneycomb(ctx context.Context, c *cli.Context) bool {
+	if c.String("honeycomb-write-key") == "" || c.String("honeycomb-dataset") == "" {
+		return false
+	}
+
+	log.WithContext(ctx).Info("configuring honeycomb reporting")
+
+	libhoney.Init(libhoney.Config{
+		WriteKey: c.String("honeycomb-write-key"),
+		Dataset:  c.String("honeycomb-dataset"),
+	})
+
+	libhoney.AddDynamicField("meta.goroutines", func() interface{} { return runtime.NumGoroutine() })
+	libhoney.AddField("app.version", c.App.Version)
+	libhoney.AddField("service_name", c.String("librato-source"))
+	return true
+}
+
+// janitorOptsFromFlags returns the janitor options given on the command
+// line, which endpoints can override.
+func janitorOptsFromFlags(c *cli.Context) (*vspherejanitor.JanitorOpts, error) {
+	janitorOpts := &vspherejanitor.JanitorOpts{
+		Cutoff:                 c.Duration("cutoff"),
+		ZeroUptimeCutoff:       c.Duration("zero-uptime-cutoff"),
+		CreationCutoff:         c.Duration("creation-cutoff"),
+		SuspendedCutoff:        c.Duration("suspended-cutoff"),
+		UnregisterBroken:       c.Bool("unregister-broken"),
+		UnregisterDeleteFiles:  c.Bool("unregister-delete-files"),
+		BrokenCutoff:           c.Duration("broken-cutoff"),
+		SkipDestroy:            c.Bool("skip-destroy"),
+		SkipNoBootTime:         c.Bool("skip-no-boot-time"),
+		Concurrency:            c.Int("concurrency"),
+		RatePerSecond:          c.Int("rate-per-second"),
+		RateBurst:              c.Int("rate-burst"),
+		MaxDestroysPerCycle:    c.Int("max-destroys-per-cycle"),
+		Order:                  vspherejanitor.CleanupOrder(c.String("cleanup-order")),
+		NotifyFailureThreshold: c.Int("notify-failure-threshold"),
+		MaxRetries:             c.Int("max-retries"),
+		RetryBackoff:           c.Duration("retry-backoff"),
+		MaxRetryBackoff:        c.Duration("max-retry-backoff"),
+		MaxFailedAttempts:      c.Int("max-failed-attempts"),
+		PowerOffTimeout:        c.Duration("power-off-timeout"),
+		DestroyTimeout:         c.Duration("destroy-timeout"),
+		PathConcurrency:        c.Int("path-concurrency"),
+		GlobalConcurrency:      c.Int("global-concurrency"),
+	}
+
+	if c.Bool("adaptive-throttle") {
+		janitorOpts.AdaptiveThrottle = &vspherejanitor.AdaptiveThrottleOpts{
+			LatencyThreshold: c.Duration("adaptive-throttle-latency-threshold"),
+			DecreaseFactor:   c.Float64("adaptive-throttle-decrease-factor"),
+			IncreaseWindow:   c.Int("adaptive-throttle-increase-window"),
+			Cooldown:         c.Duration("adaptive-throttle-cooldown"),
+			MinConcurrency:   1,
+			MinRate:          1,
+		}
+	}
+
+	if len(c.StringSlice("destroy-schedule-allow")) > 0 || len(c.StringSlice("destroy-schedule-blackout")) > 0 {
+		schedule, err := vspherejanitor.NewDestroySchedule(
+			c.StringSlice("destroy-schedule-allow"),
+			c.StringSlice("destroy-schedule-blackout"),
+			c.String("destroy-schedule-timezone"))
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid destroy schedule")
 		}
+		janitorOpts.DestroySchedule = schedule
+	}
 
-		log.WithContext(ctx).WithField("duration", cleanupLoopSleep).Info("sleeping")
-		time.Sleep(cleanupLoopSleep)
+	if c.Duration("pressure-cutoff") > 0 {
+		janitorOpts.Pressure = &vspherejanitor.PressureOpts{
+			Source: vspherejanitor.PressureSource{
+				ResourcePool: c.String("pressure-resource-pool"),
+				Datastores:   c.StringSlice("pressure-datastore"),
+			},
+			CPUThreshold:     c.Float64("pressure-cpu-threshold"),
+			MemoryThreshold:  c.Float64("pressure-memory-threshold"),
+			StorageThreshold: c.Float64("pressure-storage-threshold"),
+			Cutoff:           c.Duration("pressure-cutoff"),
+		}
 	}
 
-	return nil
+	return janitorOpts, nil
+}
+
+func newWebhook(c *cli.Context) (*notify.Webhook, error) {
+	headers := make(map[string]string)
+	for _, header := range c.StringSlice("notify-webhook-header") {
+		parts := strings.SplitN(header, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid webhook header %q, expected 'Name: value'", header)
+		}
+		headers[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	tmpl := ""
+	if c.String("notify-webhook-template") != "" {
+		b, err := ioutil.ReadFile(c.String("notify-webhook-template"))
+		if err != nil {
+			return nil, err
+		}
+		tmpl = string(b)
+	}
+
+	return notify.NewWebhook(&notify.WebhookOpts{
+		URL:          c.String("notify-webhook-url"),
+		Headers:      headers,
+		Template:     tmpl,
+		MaxRetries:   c.Int("notify-webhook-max-retries"),
+		RetryBackoff: c.Duration("notify-webhook-retry-backoff"),
+		Timeout:      c.Duration("notify-webhook-timeout"),
+	})
 }
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/travis-ci/vsphere-janitor"
+	"github.com/travis-ci/vsphere-janitor/config"
+	"github.com/travis-ci/vsphere-janitor/log"
+	"github.com/urfave/cli"
+)
+
+// A reloader reloads the config file on SIGHUP or when it changes, and
+// applies the new paths, intervals and policies to the running endpoints.
+// Adding, removing or reconnecting endpoints needs a restart.
+type reloader struct {
+	c         *cli.Context
+	path      string
+	endpoints map[string]*endpoint
+	modTime   time.Time
+}
+
+func newReloader(c *cli.Context, endpoints []*endpoint) *reloader {
+	r := &reloader{
+		c:         c,
+		path:      c.String("config"),
+		endpoints: make(map[string]*endpoint, len(endpoints)),
+	}
+
+	for _, e := range endpoints {
+		r.endpoints[e.name] = e
+	}
+
+	if info, err := os.Stat(r.path); err == nil {
+		r.modTime = info.ModTime()
+	}
+
+	return r
+}
+
+// run reloads the config file whenever the process gets SIGHUP or, if
+// interval is positive, the file's modification time changes, until ctx is
+// done.
+func (r *reloader) run(ctx context.Context, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var poll <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.WithContext(ctx).Info("got SIGHUP, reloading config")
+		case <-poll:
+			if !r.changed(ctx) {
+				continue
+			}
+			log.WithContext(ctx).Info("config file changed, reloading it")
+		}
+
+		err := r.reload(ctx)
+		if err != nil {
+			log.WithContext(ctx).WithError(err).Error("couldn't reload config, keeping the current one")
+		}
+	}
+}
+
+func (r *reloader) changed(ctx context.Context) bool {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		log.WithContext(ctx).WithError(err).Warn("couldn't check config file for changes")
+		return false
+	}
+
+	if info.ModTime().Equal(r.modTime) {
+		return false
+	}
+
+	r.modTime = info.ModTime()
+	return true
+}
+
+// reload loads and validates the whole config file before applying any of
+// it, so a mistake in one endpoint doesn't leave the others half updated.
+func (r *reloader) reload(ctx context.Context) error {
+	cfg, err := config.Load(r.path)
+	if err != nil {
+		return err
+	}
+
+	type update struct {
+		e    *endpoint
+		ec   *config.Endpoint
+		opts *vspherejanitor.JanitorOpts
+	}
+
+	updates := []update{}
+	seen := make(map[string]bool, len(cfg.Endpoints))
+	for _, ec := range cfg.Endpoints {
+		seen[ec.Name] = true
+
+		e, ok := r.endpoints[ec.Name]
+		if !ok {
+			log.WithContext(ctx).WithField("endpoint", ec.Name).Warn("new endpoint in config, restart to add it")
+			continue
+		}
+
+		if !e.config.SameConnection(ec) {
+			log.WithContext(ctx).WithField("endpoint", ec.Name).Warn("connection settings of endpoint changed, restart to apply them")
+		}
+
+		opts, err := endpointJanitorOpts(r.c, ec, e.notifier, e.registry)
+		if err != nil {
+			return errors.Wrapf(err, "endpoint %s", ec.Name)
+		}
+
+		updates = append(updates, update{e: e, ec: ec, opts: opts})
+	}
+
+	for name := range r.endpoints {
+		if !seen[name] {
+			log.WithContext(ctx).WithField("endpoint", name).Warn("endpoint removed from config, restart to remove it")
+		}
+	}
+
+	for _, u := range updates {
+		ctx := log.ContextWithField(ctx, "endpoint", u.e.name)
+
+		if !reflect.DeepEqual(u.e.getPaths(), u.ec.Paths) {
+			log.WithContext(ctx).WithField("paths", u.ec.Paths).WithField("old_paths", u.e.getPaths()).Info("changing paths")
+			u.e.setPaths(u.ec.Paths)
+		}
+
+		oldInterval, newInterval := endpointInterval(r.c, u.e.config), endpointInterval(r.c, u.ec)
+		if oldInterval != newInterval {
+			log.WithContext(ctx).WithField("interval", newInterval).WithField("old_interval", oldInterval).Info("changing interval")
+			u.e.scheduler.SetInterval(newInterval)
+		}
+
+		// the options were validated above, so this can't fail. A running
+		// cycle keeps the old options, and the next one uses the new ones.
+		err := u.e.janitor.SetOpts(ctx, u.opts)
+		if err != nil {
+			return errors.Wrapf(err, "endpoint %s", u.e.name)
+		}
+
+		u.e.config = u.ec
+	}
+
+	log.WithContext(ctx).Info("reloaded config")
+	return nil
+}
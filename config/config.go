@@ -0,0 +1,287 @@
+// Package config reads the configuration file that lists the vSphere
+// endpoints a janitor process manages, with their credentials, paths and
+// policies.
+package config
+
+import (
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"time"
+
+	"github.com/pkg/errors"
+	vspherejanitor "github.com/travis-ci/vsphere-janitor"
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the contents of a configuration file.
+type Config struct {
+	Endpoints []*Endpoint `yaml:"endpoints"`
+}
+
+// An Endpoint is a vCenter and the paths to clean up in it. Settings that
+// aren't given fall back to the command line flags.
+type Endpoint struct {
+	// Name identifies the endpoint in logs, metrics and health checks.
+	Name string `yaml:"name"`
+
+	URL             string `yaml:"url"`
+	Username        string `yaml:"username"`
+	Password        string `yaml:"password"`
+	PasswordFile    string `yaml:"password_file"`
+	PasswordCommand string `yaml:"password_command"`
+
+	Insecure    *bool    `yaml:"insecure"`
+	CAFile      string   `yaml:"ca_file"`
+	Thumbprints []string `yaml:"thumbprints"`
+
+	Paths []string `yaml:"paths"`
+
+	// Interval is the time between the starts of two cycles.
+	Interval *Duration `yaml:"interval"`
+
+	Policy Policy `yaml:"policy"`
+}
+
+// SameConnection returns true if e and other connect to the same vCenter in
+// the same way, so only their paths, interval or policy differ.
+func (e *Endpoint) SameConnection(other *Endpoint) bool {
+	return e.URL == other.URL &&
+		e.Username == other.Username &&
+		e.Password == other.Password &&
+		e.PasswordFile == other.PasswordFile &&
+		e.PasswordCommand == other.PasswordCommand &&
+		reflect.DeepEqual(e.Insecure, other.Insecure) &&
+		e.CAFile == other.CAFile &&
+		reflect.DeepEqual(e.Thumbprints, other.Thumbprints)
+}
+
+// Policy overrides the cleanup policy of the janitor for an endpoint. Only
+// the fields that are set are applied.
+type Policy struct {
+	Cutoff                *Duration `yaml:"cutoff"`
+	ZeroUptimeCutoff      *Duration `yaml:"zero_uptime_cutoff"`
+	CreationCutoff        *Duration `yaml:"creation_cutoff"`
+	SuspendedCutoff       *Duration `yaml:"suspended_cutoff"`
+	SkipDestroy           *bool     `yaml:"skip_destroy"`
+	SkipNoBootTime        *bool     `yaml:"skip_no_boot_time"`
+	UnregisterBroken      *bool     `yaml:"unregister_broken"`
+	UnregisterDeleteFiles *bool     `yaml:"unregister_delete_files"`
+	BrokenCutoff          *Duration `yaml:"broken_cutoff"`
+	Concurrency           *int      `yaml:"concurrency"`
+	RatePerSecond         *int      `yaml:"rate_per_second"`
+	MaxDestroysPerCycle   *int      `yaml:"max_destroys_per_cycle"`
+	CleanupOrder          *string   `yaml:"cleanup_order"`
+
+	DestroySchedule      *Schedule            `yaml:"destroy_schedule"`
+	PathDestroySchedules map[string]*Schedule `yaml:"path_destroy_schedules"`
+
+	Pressure *Pressure `yaml:"pressure"`
+}
+
+// Apply sets the fields of opts that p overrides.
+func (p *Policy) Apply(opts *vspherejanitor.JanitorOpts) {
+	if p.Cutoff != nil {
+		opts.Cutoff = time.Duration(*p.Cutoff)
+	}
+	if p.ZeroUptimeCutoff != nil {
+		opts.ZeroUptimeCutoff = time.Duration(*p.ZeroUptimeCutoff)
+	}
+	if p.CreationCutoff != nil {
+		opts.CreationCutoff = time.Duration(*p.CreationCutoff)
+	}
+	if p.SuspendedCutoff != nil {
+		opts.SuspendedCutoff = time.Duration(*p.SuspendedCutoff)
+	}
+	if p.SkipDestroy != nil {
+		opts.SkipDestroy = *p.SkipDestroy
+	}
+	if p.SkipNoBootTime != nil {
+		opts.SkipNoBootTime = *p.SkipNoBootTime
+	}
+	if p.UnregisterBroken != nil {
+		opts.UnregisterBroken = *p.UnregisterBroken
+	}
+	if p.UnregisterDeleteFiles != nil {
+		opts.UnregisterDeleteFiles = *p.UnregisterDeleteFiles
+	}
+	if p.BrokenCutoff != nil {
+		opts.BrokenCutoff = time.Duration(*p.BrokenCutoff)
+	}
+	if p.Concurrency != nil {
+		opts.Concurrency = *p.Concurrency
+	}
+	if p.RatePerSecond != nil {
+		opts.RatePerSecond = *p.RatePerSecond
+	}
+	if p.MaxDestroysPerCycle != nil {
+		opts.MaxDestroysPerCycle = *p.MaxDestroysPerCycle
+	}
+	if p.CleanupOrder != nil {
+		opts.Order = vspherejanitor.CleanupOrder(*p.CleanupOrder)
+	}
+	if p.DestroySchedule != nil {
+		opts.DestroySchedule = p.DestroySchedule.schedule
+	}
+	if p.PathDestroySchedules != nil {
+		opts.PathDestroySchedules = make(map[string]*vspherejanitor.DestroySchedule, len(p.PathDestroySchedules))
+		for path, schedule := range p.PathDestroySchedules {
+			opts.PathDestroySchedules[path] = schedule.schedule
+		}
+	}
+	if p.Pressure != nil {
+		opts.Pressure = &vspherejanitor.PressureOpts{
+			Source: vspherejanitor.PressureSource{
+				ResourcePool: p.Pressure.ResourcePool,
+				Datastores:   p.Pressure.Datastores,
+			},
+			CPUThreshold:     p.Pressure.CPUThreshold,
+			MemoryThreshold:  p.Pressure.MemoryThreshold,
+			StorageThreshold: p.Pressure.StorageThreshold,
+			Cutoff:           time.Duration(p.Pressure.Cutoff),
+		}
+	}
+}
+
+// Pressure makes the janitor clean up more aggressively while capacity runs
+// low, written like
+//
+//	cutoff: 30m
+//	resource_pool: /dc1/host/cluster1/Resources
+//	datastores: [/dc1/datastore/ssd1]
+//	memory_threshold: 0.9
+//	storage_threshold: 0.85
+type Pressure struct {
+	Cutoff           Duration `yaml:"cutoff"`
+	ResourcePool     string   `yaml:"resource_pool"`
+	Datastores       []string `yaml:"datastores"`
+	CPUThreshold     float64  `yaml:"cpu_threshold"`
+	MemoryThreshold  float64  `yaml:"memory_threshold"`
+	StorageThreshold float64  `yaml:"storage_threshold"`
+}
+
+// A Schedule is a destroy schedule, written like
+//
+//	timezone: Europe/Berlin
+//	allow: ["* 0-7,19-23 * * 1-5", "* * * * 0,6"]
+//	blackout: ["* 2-5 1 * *"]
+type Schedule struct {
+	schedule *vspherejanitor.DestroySchedule
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (s *Schedule) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var raw struct {
+		Timezone string   `yaml:"timezone"`
+		Allow    []string `yaml:"allow"`
+		Blackout []string `yaml:"blackout"`
+	}
+	err := unmarshal(&raw)
+	if err != nil {
+		return err
+	}
+
+	schedule, err := vspherejanitor.NewDestroySchedule(raw.Allow, raw.Blackout, raw.Timezone)
+	if err != nil {
+		return err
+	}
+
+	s.schedule = schedule
+	return nil
+}
+
+// Duration is a time.Duration written like "2h30m" in the file.
+type Duration time.Duration
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	err := unmarshal(&s)
+	if err != nil {
+		return err
+	}
+
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalYAML implements yaml.Marshaler.
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// Load reads and validates the configuration file at path.
+func Load(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't read config file")
+	}
+
+	return Parse(b)
+}
+
+// Parse parses and validates the contents of a configuration file.
+func Parse(b []byte) (*Config, error) {
+	config := &Config{}
+	err := yaml.Unmarshal(b, config)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't parse config file")
+	}
+
+	err = config.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// Validate checks that every endpoint has a unique name, a URL and paths,
+// and that the settings it overrides make sense on their own. The policy as a
+// whole is validated once it is combined with the flags.
+func (c *Config) Validate() error {
+	if len(c.Endpoints) == 0 {
+		return errors.New("no endpoints configured")
+	}
+
+	names := make(map[string]bool, len(c.Endpoints))
+	for i, endpoint := range c.Endpoints {
+		if !validName.MatchString(endpoint.Name) {
+			return errors.Errorf("endpoint %d: name %q must only contain letters, digits, '_' and '-'", i, endpoint.Name)
+		}
+		if names[endpoint.Name] {
+			return errors.Errorf("endpoint %s: duplicate name", endpoint.Name)
+		}
+		names[endpoint.Name] = true
+
+		if endpoint.URL == "" {
+			return errors.Errorf("endpoint %s: missing url", endpoint.Name)
+		}
+		if len(endpoint.Paths) == 0 {
+			return errors.Errorf("endpoint %s: missing paths", endpoint.Name)
+		}
+		if endpoint.Interval != nil && *endpoint.Interval <= 0 {
+			return errors.Errorf("endpoint %s: interval must be positive", endpoint.Name)
+		}
+		if endpoint.Policy.Concurrency != nil && *endpoint.Policy.Concurrency < 1 {
+			return errors.Errorf("endpoint %s: concurrency must be at least 1", endpoint.Name)
+		}
+		if endpoint.Policy.RatePerSecond != nil && *endpoint.Policy.RatePerSecond <= 0 {
+			return errors.Errorf("endpoint %s: rate per second must be positive", endpoint.Name)
+		}
+		for path, schedule := range endpoint.Policy.PathDestroySchedules {
+			if schedule == nil {
+				return errors.Errorf("endpoint %s: destroy schedule of path %s is empty", endpoint.Name, path)
+			}
+		}
+	}
+
+	return nil
+}
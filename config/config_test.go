@@ -0,0 +1,134 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	vspherejanitor "github.com/travis-ci/vsphere-janitor"
+)
+
+func assertEqual(tb testing.TB, name string, expected, actual interface{}) {
+	if expected != actual {
+		tb.Errorf("%s: expected %v, but was %v", name, expected, actual)
+	}
+}
+
+func assertOk(tb testing.TB, name string, err error) {
+	if err != nil {
+		tb.Fatalf("%s: returned error: %v", name, err)
+	}
+}
+
+func assertError(tb testing.TB, name string, err error) {
+	if err == nil {
+		tb.Fatalf("%s: didn't return error", name)
+	}
+}
+
+const testConfig = `
+endpoints:
+  - name: dc1
+    url: https://vcenter1.example.com/sdk
+    username: janitor
+    password_file: /etc/vsphere-janitor/dc1-password
+    paths:
+      - /dc1/vm/jobs
+      - /dc1/vm/more-jobs
+    interval: 30s
+    policy:
+      cutoff: 3h
+      skip_destroy: true
+      cleanup_order: largest
+      destroy_schedule:
+        timezone: Europe/Berlin
+        allow: ["* 0-7,19-23 * * 1-5", "* * * * 0,6"]
+      path_destroy_schedules:
+        /dc1/vm/more-jobs:
+          blackout: ["* * * * *"]
+      pressure:
+        cutoff: 30m
+        resource_pool: /dc1/host/cluster1/Resources
+        memory_threshold: 0.9
+  - name: dc2
+    url: https://vcenter2.example.com/sdk
+    insecure: false
+    thumbprints: ["AA:BB"]
+    paths: [/dc2/vm/jobs]
+`
+
+func TestParse(t *testing.T) {
+	config, err := Parse([]byte(testConfig))
+	assertOk(t, "Parse", err)
+	assertEqual(t, "len(Endpoints)", 2, len(config.Endpoints))
+
+	dc1 := config.Endpoints[0]
+	assertEqual(t, "dc1 name", "dc1", dc1.Name)
+	assertEqual(t, "dc1 password file", "/etc/vsphere-janitor/dc1-password", dc1.PasswordFile)
+	assertEqual(t, "dc1 paths", 2, len(dc1.Paths))
+	assertEqual(t, "dc1 interval", 30*time.Second, time.Duration(*dc1.Interval))
+	assertEqual(t, "dc1 insecure unset", true, dc1.Insecure == nil)
+
+	dc2 := config.Endpoints[1]
+	assertEqual(t, "dc2 insecure", false, *dc2.Insecure)
+	assertEqual(t, "dc2 interval unset", true, dc2.Interval == nil)
+
+	opts := &vspherejanitor.JanitorOpts{
+		Cutoff:      time.Hour,
+		Concurrency: 4,
+	}
+	dc1.Policy.Apply(opts)
+	assertEqual(t, "dc1 cutoff", 3*time.Hour, opts.Cutoff)
+	assertEqual(t, "dc1 skip destroy", true, opts.SkipDestroy)
+	assertEqual(t, "dc1 concurrency", 4, opts.Concurrency)
+	assertEqual(t, "dc1 cleanup order", vspherejanitor.OrderLargest, opts.Order)
+
+	// 2016-01-15 is a Friday, and 12:00 UTC is 13:00 in Berlin
+	noon := time.Date(2016, 1, 15, 12, 0, 0, 0, time.UTC)
+	assertEqual(t, "dc1 destroy allowed at noon", false, opts.DestroySchedule.DestroyAllowed(noon))
+	assertEqual(t, "dc1 destroy allowed at 19:00 in Berlin", true, opts.DestroySchedule.DestroyAllowed(noon.Add(6*time.Hour)))
+	assertEqual(t, "dc1 more jobs destroy allowed", false, opts.PathDestroySchedules["/dc1/vm/more-jobs"].DestroyAllowed(noon.Add(6*time.Hour)))
+	assertEqual(t, "dc1 pressure cutoff", 30*time.Minute, opts.Pressure.Cutoff)
+	assertEqual(t, "dc1 pressure resource pool", "/dc1/host/cluster1/Resources", opts.Pressure.Source.ResourcePool)
+	assertEqual(t, "dc1 pressure memory threshold", 0.9, opts.Pressure.MemoryThreshold)
+}
+
+func TestParseInvalid(t *testing.T) {
+	for name, contents := range map[string]string{
+		"no endpoints":     `endpoints: []`,
+		"missing name":     `endpoints: [{url: "https://vc/sdk", paths: [/a]}]`,
+		"invalid name":     `endpoints: [{name: "dc 1", url: "https://vc/sdk", paths: [/a]}]`,
+		"duplicate name":   `endpoints: [{name: dc1, url: "https://vc/sdk", paths: [/a]}, {name: dc1, url: "https://vc2/sdk", paths: [/b]}]`,
+		"missing url":      `endpoints: [{name: dc1, paths: [/a]}]`,
+		"missing paths":    `endpoints: [{name: dc1, url: "https://vc/sdk"}]`,
+		"invalid cutoff":   `endpoints: [{name: dc1, url: "https://vc/sdk", paths: [/a], policy: {cutoff: soon}}]`,
+		"invalid schedule": `endpoints: [{name: dc1, url: "https://vc/sdk", paths: [/a], policy: {destroy_schedule: {allow: ["* * *"]}}}]`,
+		"invalid timezone": `endpoints: [{name: dc1, url: "https://vc/sdk", paths: [/a], policy: {destroy_schedule: {timezone: Mars/Olympus}}}]`,
+		"null schedule":    `endpoints: [{name: dc1, url: "https://vc/sdk", paths: [/a], policy: {path_destroy_schedules: {/a: null}}}]`,
+		"zero interval":    `endpoints: [{name: dc1, url: "https://vc/sdk", paths: [/a], interval: 0s}]`,
+		"zero rate":        `endpoints: [{name: dc1, url: "https://vc/sdk", paths: [/a], policy: {rate_per_second: 0}}]`,
+		"not yaml at all":  `{{{`,
+	} {
+		_, err := Parse([]byte(contents))
+		assertError(t, name, err)
+	}
+}
+
+func TestEndpointSameConnection(t *testing.T) {
+	config, err := Parse([]byte(testConfig))
+	assertOk(t, "Parse", err)
+
+	dc1 := config.Endpoints[0]
+	changed := *dc1
+	changed.Paths = []string{"/dc1/vm/other"}
+	changed.Policy = Policy{}
+	assertEqual(t, "same connection with other paths and policy", true, dc1.SameConnection(&changed))
+
+	changed.PasswordFile = "/etc/vsphere-janitor/other-password"
+	assertEqual(t, "same connection with other password file", false, dc1.SameConnection(&changed))
+
+	dc2 := config.Endpoints[1]
+	insecure := true
+	changed = *dc2
+	changed.Insecure = &insecure
+	assertEqual(t, "same connection with other insecure", false, dc2.SameConnection(&changed))
+}
@@ -0,0 +1,207 @@
+package vspherejanitor
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// control holds the runtime state of a janitor that operators can change
+// while it is running: paused paths and exempted VMs. It also remembers the
+// results of the last cleanup of every path.
+type control struct {
+	mutex       sync.Mutex
+	paused      bool
+	pausedPaths map[string]bool
+	exemptions  map[string]Exemption
+	lastResults map[string]PathResult
+}
+
+func newControl() *control {
+	return &control{
+		pausedPaths: make(map[string]bool),
+		exemptions:  make(map[string]Exemption),
+		lastResults: make(map[string]PathResult),
+	}
+}
+
+// An Exemption keeps a VM from being cleaned up until it expires.
+type Exemption struct {
+	// VM is the ID or name of the VM.
+	VM     string    `json:"vm"`
+	Until  time.Time `json:"until"`
+	Reason string    `json:"reason"`
+}
+
+// A PathResult is the outcome of the last cleanup of a path.
+type PathResult struct {
+	Path         string        `json:"path"`
+	Start        time.Time     `json:"start"`
+	Duration     time.Duration `json:"duration"`
+	VMs          int           `json:"vms"`
+	Destroyed    int           `json:"destroyed"`
+	Unregistered int           `json:"unregistered"`
+	Failed       int           `json:"failed"`
+	Skipped      int           `json:"skipped"`
+	Paused       bool          `json:"paused"`
+	ObserveOnly  bool          `json:"observe_only"`
+	PressureMode bool          `json:"pressure_mode"`
+	Reclaimed    Resources     `json:"reclaimed"`
+	Err          string        `json:"error,omitempty"`
+}
+
+// Pause stops the janitor from cleaning up any path until Resume is called.
+// A running cleanup finishes the power offs and destroys in progress, and
+// skips the VMs it hasn't gotten to yet.
+func (j *Janitor) Pause() {
+	j.control.mutex.Lock()
+	defer j.control.mutex.Unlock()
+	j.control.paused = true
+}
+
+// Resume undoes Pause. Paths paused with PausePath stay paused.
+func (j *Janitor) Resume() {
+	j.control.mutex.Lock()
+	defer j.control.mutex.Unlock()
+	j.control.paused = false
+}
+
+// PausePath stops the janitor from cleaning up path until ResumePath is
+// called, like Pause.
+func (j *Janitor) PausePath(path string) {
+	j.control.mutex.Lock()
+	defer j.control.mutex.Unlock()
+	j.control.pausedPaths[path] = true
+}
+
+// ResumePath undoes PausePath.
+func (j *Janitor) ResumePath(path string) {
+	j.control.mutex.Lock()
+	defer j.control.mutex.Unlock()
+	delete(j.control.pausedPaths, path)
+}
+
+// Paused returns whether the janitor is paused as a whole, and which paths
+// are paused on their own.
+func (j *Janitor) Paused() (bool, []string) {
+	j.control.mutex.Lock()
+	defer j.control.mutex.Unlock()
+
+	paths := make([]string, 0, len(j.control.pausedPaths))
+	for path := range j.control.pausedPaths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	return j.control.paused, paths
+}
+
+func (j *Janitor) pathPaused(path string) bool {
+	j.control.mutex.Lock()
+	defer j.control.mutex.Unlock()
+	return j.control.paused || j.control.pausedPaths[path]
+}
+
+// Exempt keeps the VM with the given ID or name from being cleaned up until
+// the exemption expires, replacing any earlier exemption of the same VM.
+func (j *Janitor) Exempt(exemption Exemption) {
+	j.control.mutex.Lock()
+	defer j.control.mutex.Unlock()
+	j.control.exemptions[exemption.VM] = exemption
+}
+
+// Unexempt removes the exemption of a VM, if there is one.
+func (j *Janitor) Unexempt(vm string) {
+	j.control.mutex.Lock()
+	defer j.control.mutex.Unlock()
+	delete(j.control.exemptions, vm)
+}
+
+// Exemptions returns the exemptions that haven't expired at now, sorted by
+// VM, forgetting the ones that have.
+func (j *Janitor) Exemptions(now time.Time) []Exemption {
+	j.control.mutex.Lock()
+	defer j.control.mutex.Unlock()
+
+	exemptions := make([]Exemption, 0, len(j.control.exemptions))
+	for vm, exemption := range j.control.exemptions {
+		if !now.Before(exemption.Until) {
+			delete(j.control.exemptions, vm)
+			continue
+		}
+		exemptions = append(exemptions, exemption)
+	}
+	sort.Slice(exemptions, func(a, b int) bool { return exemptions[a].VM < exemptions[b].VM })
+
+	return exemptions
+}
+
+// exemption returns the exemption of vm that is in effect at now, if any.
+func (j *Janitor) exemption(vm VirtualMachine, now time.Time) (Exemption, bool) {
+	j.control.mutex.Lock()
+	defer j.control.mutex.Unlock()
+
+	for _, key := range []string{vm.ID(), vm.Name()} {
+		exemption, ok := j.control.exemptions[key]
+		if ok && key != "" && now.Before(exemption.Until) {
+			return exemption, true
+		}
+	}
+
+	return Exemption{}, false
+}
+
+// LastResults returns the result of the last cleanup of every path, sorted
+// by path.
+func (j *Janitor) LastResults() []PathResult {
+	j.control.mutex.Lock()
+	defer j.control.mutex.Unlock()
+
+	results := make([]PathResult, 0, len(j.control.lastResults))
+	for _, result := range j.control.lastResults {
+		results = append(results, result)
+	}
+	sort.Slice(results, func(a, b int) bool { return results[a].Path < results[b].Path })
+
+	return results
+}
+
+func (j *Janitor) recordResult(result PathResult) {
+	j.control.mutex.Lock()
+	defer j.control.mutex.Unlock()
+	j.control.lastResults[result.Path] = result
+}
+
+// ZeroUptimeFirstSeen returns when the janitor first saw each VM that has
+// zero uptime and no boot time, by VM ID.
+func (j *Janitor) ZeroUptimeFirstSeen() map[string]time.Time {
+	j.zeroUptimeFirstSeenMutex.Lock()
+	defer j.zeroUptimeFirstSeenMutex.Unlock()
+
+	firstSeen := make(map[string]time.Time, len(j.zeroUptimeFirstSeen))
+	for id, t := range j.zeroUptimeFirstSeen {
+		firstSeen[id] = t
+	}
+
+	return firstSeen
+}
+
+// RememberZeroUptimeFirstSeen remembers when VMs were first seen with zero
+// uptime and no boot time, by VM ID, such as what ZeroUptimeFirstSeen of a
+// running janitor returned. VMs the janitor remembers already keep their
+// time.
+func (j *Janitor) RememberZeroUptimeFirstSeen(firstSeen map[string]time.Time) {
+	j.zeroUptimeFirstSeenMutex.Lock()
+	defer j.zeroUptimeFirstSeenMutex.Unlock()
+
+	for id, t := range firstSeen {
+		if _, ok := j.zeroUptimeFirstSeen[id]; !ok {
+			j.zeroUptimeFirstSeen[id] = t
+		}
+	}
+}
+
+// Opts returns a copy of the options the janitor currently uses.
+func (j *Janitor) Opts() JanitorOpts {
+	return *j.view().opts
+}
@@ -0,0 +1,246 @@
+package vspherejanitor
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A CronExpr is a cron expression with the usual five fields, minute, hour,
+// day of month, month and day of week, matched against points in time.
+// Fields can be "*", numbers, ranges like "1-5" and lists of those, each
+// with an optional step like "*/15". Like in cron, if both day fields are
+// restricted, a time matches if either of them does. A field that lists
+// every day, like "1-31" or "0-6", isn't restricted.
+type CronExpr struct {
+	expr string
+
+	minutes, hours, daysOfMonth, months, daysOfWeek uint64
+	anyDayOfMonth, anyDayOfWeek                     bool
+}
+
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// ParseCron parses a cron expression.
+func ParseCron(expr string) (*CronExpr, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, errors.Errorf("cron expression %q must have %d fields, but has %d", expr, len(cronFields), len(fields))
+	}
+
+	sets := make([]uint64, len(fields))
+	for i, field := range fields {
+		set, err := parseCronField(field, cronFields[i].min, cronFields[i].max)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid %s in cron expression %q", cronFields[i].name, expr)
+		}
+		sets[i] = set
+	}
+
+	// both 0 and 7 are Sunday
+	if sets[4]&(1<<7) != 0 {
+		sets[4] |= 1
+	}
+
+	return &CronExpr{
+		expr:          expr,
+		minutes:       sets[0],
+		hours:         sets[1],
+		daysOfMonth:   sets[2],
+		months:        sets[3],
+		daysOfWeek:    sets[4],
+		anyDayOfMonth: sets[2] == cronRange(1, 31),
+		anyDayOfWeek:  sets[4]&cronRange(0, 6) == cronRange(0, 6),
+	}, nil
+}
+
+// cronRange returns the bit set of the values from min to max.
+func cronRange(min, max int) uint64 {
+	return (1<<uint(max+1) - 1) &^ (1<<uint(min) - 1)
+}
+
+// parseCronField returns the values a field matches as a bit set.
+func parseCronField(field string, min, max int) (uint64, error) {
+	var set uint64
+
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step < 1 {
+				return 0, errors.Errorf("invalid step in %q", part)
+			}
+			rangePart = part[:i]
+		}
+
+		low, high := min, max
+		switch {
+		case rangePart == "*":
+		case strings.Contains(rangePart, "-"):
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			low, err = strconv.Atoi(bounds[0])
+			if err != nil {
+				return 0, errors.Errorf("invalid range %q", rangePart)
+			}
+			high, err = strconv.Atoi(bounds[1])
+			if err != nil {
+				return 0, errors.Errorf("invalid range %q", rangePart)
+			}
+		default:
+			value, err := strconv.Atoi(rangePart)
+			if err != nil {
+				return 0, errors.Errorf("invalid value %q", rangePart)
+			}
+			low, high = value, value
+			if strings.Contains(part, "/") {
+				high = max
+			}
+		}
+
+		if low < min || high > max || low > high {
+			return 0, errors.Errorf("%q is out of range %d-%d", rangePart, min, max)
+		}
+
+		for value := low; value <= high; value += step {
+			set |= 1 << uint(value)
+		}
+	}
+
+	return set, nil
+}
+
+// Matches returns true if the expression matches the minute t is in.
+func (c *CronExpr) Matches(t time.Time) bool {
+	if c.minutes&(1<<uint(t.Minute())) == 0 ||
+		c.hours&(1<<uint(t.Hour())) == 0 ||
+		c.months&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+
+	dayOfMonth := c.daysOfMonth&(1<<uint(t.Day())) != 0
+	dayOfWeek := c.daysOfWeek&(1<<uint(t.Weekday())) != 0
+
+	switch {
+	case c.anyDayOfMonth && c.anyDayOfWeek:
+		return true
+	case c.anyDayOfMonth:
+		return dayOfWeek
+	case c.anyDayOfWeek:
+		return dayOfMonth
+	default:
+		return dayOfMonth || dayOfWeek
+	}
+}
+
+func (c *CronExpr) String() string {
+	return c.expr
+}
+
+// A DestroySchedule says when the janitor may clean up the VMs in a path.
+// Outside of it, cycles still run and keep track of VMs, but only observe:
+// they don't power off, destroy or unregister anything.
+type DestroySchedule struct {
+	// Allow lists when cleaning up is allowed. If it is empty, it always is,
+	// unless Blackout says otherwise.
+	Allow []*CronExpr
+
+	// Blackout lists when cleaning up is never allowed, such as during
+	// vCenter maintenance windows. It wins over Allow.
+	Blackout []*CronExpr
+
+	// Location is the time zone the expressions are in. It defaults to UTC.
+	Location *time.Location
+}
+
+// DestroyAllowed returns true if VMs may be cleaned up at t.
+func (s *DestroySchedule) DestroyAllowed(t time.Time) bool {
+	location := s.Location
+	if location == nil {
+		location = time.UTC
+	}
+	t = t.In(location)
+
+	for _, expr := range s.Blackout {
+		if expr.Matches(t) {
+			return false
+		}
+	}
+
+	if len(s.Allow) == 0 {
+		return true
+	}
+
+	for _, expr := range s.Allow {
+		if expr.Matches(t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *DestroySchedule) String() string {
+	location := "UTC"
+	if s.Location != nil {
+		location = s.Location.String()
+	}
+
+	return "allow=" + joinCron(s.Allow) + " blackout=" + joinCron(s.Blackout) + " tz=" + location
+}
+
+func joinCron(exprs []*CronExpr) string {
+	if len(exprs) == 0 {
+		return "[]"
+	}
+
+	strs := make([]string, len(exprs))
+	for i, expr := range exprs {
+		strs[i] = expr.String()
+	}
+	return "[" + strings.Join(strs, "; ") + "]"
+}
+
+// NewDestroySchedule parses the allow and blackout cron expressions of a
+// DestroySchedule in the time zone with the given name.
+func NewDestroySchedule(allow, blackout []string, timezone string) (*DestroySchedule, error) {
+	s := &DestroySchedule{}
+
+	if timezone != "" {
+		location, err := time.LoadLocation(timezone)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid time zone %q", timezone)
+		}
+		s.Location = location
+	}
+
+	for _, expr := range allow {
+		parsed, err := ParseCron(expr)
+		if err != nil {
+			return nil, err
+		}
+		s.Allow = append(s.Allow, parsed)
+	}
+
+	for _, expr := range blackout {
+		parsed, err := ParseCron(expr)
+		if err != nil {
+			return nil, err
+		}
+		s.Blackout = append(s.Blackout, parsed)
+	}
+
+	return s, nil
+}
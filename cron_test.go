@@ -0,0 +1,63 @@
+package vspherejanitor_test
+
+import (
+	"testing"
+	"time"
+
+	vspherejanitor "github.com/travis-ci/vsphere-janitor"
+)
+
+func TestCronExpr(t *testing.T) {
+	// 2016-01-15 is a Friday
+	friday := time.Date(2016, 1, 15, 12, 30, 0, 0, time.UTC)
+
+	for expr, expected := range map[string]bool{
+		"* * * * *":         true,
+		"30 12 * * *":       true,
+		"*/15 * * * *":      true,
+		"*/7 * * * *":       false,
+		"0-29 * * * *":      false,
+		"* 9-17 * * 1-5":    true,
+		"* * * * 0,6":       false,
+		"* * * * 5":         true,
+		"* * 1 * 5":         true,
+		"* * 1 * 6":         false,
+		"* * 15 * *":        true,
+		"* * 1-31 * 6":      false,
+		"* * 1 * 0-6":       false,
+		"* * 1 * 1-7":       false,
+		"* * * 2-12 *":      false,
+		"* 0-8,18-23 * * *": false,
+	} {
+		cron, err := vspherejanitor.ParseCron(expr)
+		assertOk(t, "ParseCron("+expr+")", err)
+		assertEqual(t, expr+" matches", expected, cron.Matches(friday))
+	}
+
+	sunday, err := vspherejanitor.ParseCron("* * * * 7")
+	assertOk(t, "ParseCron with Sunday as 7", err)
+	assertEqual(t, "Sunday as 7 matches Sunday", true, sunday.Matches(friday.Add(48*time.Hour)))
+
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "a * * * *"} {
+		_, err := vspherejanitor.ParseCron(expr)
+		assertError(t, "ParseCron("+expr+")", err)
+	}
+}
+
+func TestDestroySchedule(t *testing.T) {
+	schedule, err := vspherejanitor.NewDestroySchedule(
+		[]string{"* 0-7,19-23 * * 1-5", "* * * * 0,6"},
+		[]string{"* 2-3 * * *"},
+		"Europe/Berlin")
+	assertOk(t, "NewDestroySchedule", err)
+
+	// 12:00 UTC is 13:00 in Berlin in winter
+	noon := time.Date(2016, 1, 15, 12, 0, 0, 0, time.UTC)
+	assertEqual(t, "allowed during business hours", false, schedule.DestroyAllowed(noon))
+	assertEqual(t, "allowed in the evening", true, schedule.DestroyAllowed(noon.Add(6*time.Hour)))
+	assertEqual(t, "allowed at the weekend", true, schedule.DestroyAllowed(noon.Add(24*time.Hour)))
+	assertEqual(t, "allowed during the blackout", false, schedule.DestroyAllowed(noon.Add(13*time.Hour+30*time.Minute)))
+
+	_, err = vspherejanitor.NewDestroySchedule(nil, nil, "Mars/Olympus")
+	assertError(t, "NewDestroySchedule with invalid time zone", err)
+}
@@ -0,0 +1,64 @@
+package vspherejanitor
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// A HealthCheck returns nil if the component it checks is healthy, and
+// the reason it isn't otherwise.
+type HealthCheck func() error
+
+// Health is an http.Handler that runs all registered checks, responding
+// 200 if they all pass and 503 otherwise, with the result of every check
+// in a JSON body.
+type Health struct {
+	mutex  sync.Mutex
+	checks map[string]HealthCheck
+}
+
+// NewHealth returns a Health without any checks, which always responds 200.
+func NewHealth() *Health {
+	return &Health{checks: make(map[string]HealthCheck)}
+}
+
+// Register adds check under name, replacing any check registered under the
+// same name before.
+func (h *Health) Register(name string, check HealthCheck) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.checks[name] = check
+}
+
+type healthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+func (h *Health) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mutex.Lock()
+	checks := make(map[string]HealthCheck, len(h.checks))
+	for name, check := range h.checks {
+		checks[name] = check
+	}
+	h.mutex.Unlock()
+
+	status := http.StatusOK
+	response := healthResponse{Status: "ok", Checks: make(map[string]string, len(checks))}
+	for name, check := range checks {
+		err := check()
+		if err != nil {
+			status = http.StatusServiceUnavailable
+			response.Status = "unhealthy"
+			response.Checks[name] = err.Error()
+			continue
+		}
+		response.Checks[name] = "ok"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
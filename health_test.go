@@ -0,0 +1,37 @@
+package vspherejanitor_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	vspherejanitor "github.com/travis-ci/vsphere-janitor"
+)
+
+func TestHealth(t *testing.T) {
+	health := vspherejanitor.NewHealth()
+
+	sessionErr := errors.New("not logged in yet")
+	health.Register("vsphere_session", func() error { return sessionErr })
+
+	rec := httptest.NewRecorder()
+	health.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	assertEqual(t, "status while unhealthy", http.StatusServiceUnavailable, rec.Code)
+
+	var body struct {
+		Status string
+		Checks map[string]string
+	}
+	err := json.NewDecoder(rec.Body).Decode(&body)
+	assertOk(t, "decoding body", err)
+	assertEqual(t, "body status", "unhealthy", body.Status)
+	assertEqual(t, "vsphere_session check", "not logged in yet", body.Checks["vsphere_session"])
+
+	sessionErr = nil
+
+	rec = httptest.NewRecorder()
+	health.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+	assertEqual(t, "status while healthy", http.StatusOK, rec.Code)
+}
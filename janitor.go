@@ -2,7 +2,11 @@ package vspherejanitor
 
 import (
 	"context"
+	"fmt"
+	"reflect"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Sirupsen/logrus"
@@ -14,10 +18,56 @@ import (
 
 type Janitor struct {
 	vmLister VMLister
-	opts     *JanitorOpts
+
+	// current holds the *settings in use. Cleanups load it once when they
+	// start, so SetOpts doesn't wait for them, and they finish with the
+	// settings they started with.
+	current atomic.Value
+
+	// setOptsMutex keeps concurrent SetOpts from building settings from the
+	// same old ones.
+	setOptsMutex sync.Mutex
 
 	zeroUptimeFirstSeenMutex sync.Mutex
 	zeroUptimeFirstSeen      map[string]time.Time
+
+	brokenFirstSeenMutex sync.Mutex
+	brokenFirstSeen      map[string]time.Time
+
+	failuresMutex sync.Mutex
+	failures      map[string]int
+
+	control *control
+
+	notifications *notificationQueue
+
+	metrics metrics.Registry
+}
+
+// settings are the options of a janitor and the limiters built from them.
+type settings struct {
+	opts *JanitorOpts
+
+	// globalSem limits concurrent power off and destroys across all paths
+	// being cleaned up at the same time. It is nil if there's no limit.
+	globalSem chan struct{}
+
+	rateLimiter RateLimiter
+
+	// throttle adapts concurrency and rate to how well vCenter copes. It
+	// is nil unless AdaptiveThrottle is set.
+	throttle *AdaptiveThrottle
+}
+
+// A view is a janitor with the settings it had when an operation started,
+// which the operation uses throughout, even if SetOpts replaces them.
+type view struct {
+	*Janitor
+	*settings
+}
+
+func (j *Janitor) view() *view {
+	return &view{Janitor: j, settings: j.current.Load().(*settings)}
 }
 
 func NewJanitor(vmLister VMLister, opts *JanitorOpts) *Janitor {
@@ -30,11 +80,192 @@ func NewJanitor(vmLister VMLister, opts *JanitorOpts) *Janitor {
 		}
 	}
 
-	return &Janitor{
+	j := &Janitor{
 		vmLister:            vmLister,
-		opts:                opts,
 		zeroUptimeFirstSeen: make(map[string]time.Time),
+		brokenFirstSeen:     make(map[string]time.Time),
+		failures:            make(map[string]int),
+		control:             newControl(),
+		notifications:       newNotificationQueue(notificationQueueSize),
+		metrics:             opts.Metrics,
+	}
+
+	if j.metrics == nil {
+		j.metrics = metrics.DefaultRegistry
+	}
+
+	j.current.Store(j.configure(nil, opts))
+	go j.notifications.run(j.sendNotificationBatch)
+	return j
+}
+
+// SetOpts replaces the options of the janitor, keeping what it remembers
+// about VMs, such as when they were first seen with zero uptime. Running
+// cleanups finish with the options they started with. It returns an error
+// without changing anything if opts are invalid. The metrics registry can't
+// be changed.
+func (j *Janitor) SetOpts(ctx context.Context, opts *JanitorOpts) error {
+	err := opts.Validate()
+	if err != nil {
+		return err
+	}
+
+	j.setOptsMutex.Lock()
+	defer j.setOptsMutex.Unlock()
+
+	old := j.current.Load().(*settings)
+	changes := diffOpts(old.opts, opts)
+	if len(changes) == 0 {
+		log.WithContext(ctx).Info("janitor options unchanged")
+	} else {
+		logger := log.WithContext(ctx)
+		for _, change := range changes {
+			logger = logger.WithField(change.name, fmt.Sprintf("%v -> %v", change.old, change.new))
+		}
+		logger.Info("changing janitor options")
+	}
+
+	j.current.Store(j.configure(old, opts))
+	return nil
+}
+
+// An optChange is an option that differs between two JanitorOpts.
+type optChange struct {
+	name     string
+	old, new interface{}
+}
+
+// diffOpts returns the options that differ between old and new. Options
+// that are interfaces, such as Notifier, aren't compared.
+func diffOpts(old, new *JanitorOpts) []optChange {
+	var changes []optChange
+
+	oldValue, newValue := reflect.ValueOf(*old), reflect.ValueOf(*new)
+	for i := 0; i < oldValue.NumField(); i++ {
+		field := oldValue.Type().Field(i)
+		oldField, newField := oldValue.Field(i), newValue.Field(i)
+
+		switch field.Type.Kind() {
+		case reflect.Interface:
+			continue
+		case reflect.Ptr:
+			if oldField.IsNil() && newField.IsNil() {
+				continue
+			}
+			if !oldField.IsNil() && !newField.IsNil() && reflect.DeepEqual(oldField.Elem().Interface(), newField.Elem().Interface()) {
+				continue
+			}
+			changes = append(changes, optChange{name: field.Name, old: describeOpt(oldField), new: describeOpt(newField)})
+		default:
+			if !reflect.DeepEqual(oldField.Interface(), newField.Interface()) {
+				changes = append(changes, optChange{name: field.Name, old: describeValue(oldField), new: describeValue(newField)})
+			}
+		}
 	}
+
+	return changes
+}
+
+func describeOpt(v reflect.Value) interface{} {
+	if v.IsNil() {
+		return "unset"
+	}
+	if stringer, ok := v.Interface().(fmt.Stringer); ok {
+		return stringer.String()
+	}
+	if v.Elem().Kind() == reflect.Struct {
+		return describeStruct(v.Elem())
+	}
+	return v.Elem().Interface()
+}
+
+// describeStruct returns the fields of a struct of options as a map, with
+// values described by describeValue and interfaces, such as registries, left
+// out.
+func describeStruct(v reflect.Value) map[string]interface{} {
+	described := make(map[string]interface{})
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.Type.Kind() == reflect.Interface {
+			continue
+		}
+		described[field.Name] = describeValue(v.Field(i))
+	}
+	return described
+}
+
+// describeValue returns an option in a form that prints well: pointers, and
+// maps of them such as PathDestroySchedules, as what they point to, and
+// durations like "1h0m0s".
+func describeValue(v reflect.Value) interface{} {
+	switch {
+	case v.Kind() == reflect.Ptr:
+		return describeOpt(v)
+	case v.Kind() == reflect.Map && v.Type().Elem().Kind() == reflect.Ptr:
+		values := make(map[string]interface{}, v.Len())
+		for _, key := range v.MapKeys() {
+			values[fmt.Sprint(key.Interface())] = describeOpt(v.MapIndex(key))
+		}
+		return values
+	case v.Type() == reflect.TypeOf(time.Duration(0)):
+		return v.Interface().(time.Duration).String()
+	default:
+		return v.Interface()
+	}
+}
+
+// configure builds the settings for opts. Limiters whose options didn't
+// change from old, which is nil for a new janitor, are kept, so they keep
+// their state.
+func (j *Janitor) configure(old *settings, opts *JanitorOpts) *settings {
+	s := &settings{opts: opts}
+	if old != nil {
+		s.globalSem, s.rateLimiter, s.throttle = old.globalSem, old.rateLimiter, old.throttle
+	}
+
+	if old == nil || opts.GlobalConcurrency != old.opts.GlobalConcurrency {
+		s.globalSem = nil
+		if opts.GlobalConcurrency > 0 {
+			s.globalSem = make(chan struct{}, opts.GlobalConcurrency)
+		}
+	}
+
+	rateChanged := old == nil || opts.RateLimiter != old.opts.RateLimiter ||
+		opts.RatePerSecond != old.opts.RatePerSecond || opts.RateBurst != old.opts.RateBurst
+	if rateChanged {
+		s.rateLimiter = opts.RateLimiter
+		if s.rateLimiter == nil {
+			s.rateLimiter = NewTokenBucket(float64(opts.RatePerSecond), opts.RateBurst)
+		}
+	}
+
+	throttleChanged := rateChanged || opts.AdaptiveThrottle != nil && (old.opts.AdaptiveThrottle == nil ||
+		*opts.AdaptiveThrottle != *old.opts.AdaptiveThrottle || opts.Concurrency != old.opts.Concurrency ||
+		opts.PathConcurrency != old.opts.PathConcurrency || opts.GlobalConcurrency != old.opts.GlobalConcurrency)
+	switch {
+	case opts.AdaptiveThrottle == nil:
+		s.throttle = nil
+	case throttleChanged:
+		maxConcurrency := opts.GlobalConcurrency
+		if maxConcurrency <= 0 {
+			maxConcurrency = opts.Concurrency
+			if opts.PathConcurrency > 1 {
+				maxConcurrency *= opts.PathConcurrency
+			}
+		}
+		throttleOpts := *opts.AdaptiveThrottle
+		if throttleOpts.Metrics == nil {
+			throttleOpts.Metrics = j.metrics
+		}
+		// a kept token bucket may have been slowed down by the old
+		// throttle, and the new one never goes above the rate it starts at
+		if tb, ok := s.rateLimiter.(adjustableRateLimiter); ok && !rateChanged && opts.RateLimiter == nil {
+			tb.SetRate(float64(opts.RatePerSecond))
+		}
+		s.throttle = NewAdaptiveThrottle(&throttleOpts, maxConcurrency, s.rateLimiter)
+	}
+
+	return s
 }
 
 type JanitorOpts struct {
@@ -42,65 +273,601 @@ type JanitorOpts struct {
 	ZeroUptimeCutoff time.Duration
 	SkipDestroy      bool
 	Concurrency      int
-	RatePerSecond    int
 	SkipNoBootTime   bool
+
+	// RatePerSecond and RateBurst configure the token bucket that limits
+	// vSphere API calls made on behalf of the janitor.
+	RatePerSecond int
+	RateBurst     int
+
+	// RateLimiter, if set, is used instead of a token bucket built from
+	// RatePerSecond and RateBurst, e.g. to share one across janitors.
+	RateLimiter RateLimiter
+
+	// MaxDestroysPerCycle limits how many VMs a single Cleanup will power
+	// off and destroy. Zero means no limit.
+	MaxDestroysPerCycle int
+
+	// Notifier, if set, is sent notifications about unusual outcomes at the
+	// end of every Cleanup.
+	Notifier Notifier
+
+	// NotifyFailureThreshold is the number of consecutive failures to power
+	// off and destroy a VM after which a notification is sent.
+	NotifyFailureThreshold int
+
+	// MaxRetries is how many times a power off or destroy that failed with
+	// a transient error is retried within a single cleanup.
+	MaxRetries int
+
+	// RetryBackoff is the wait before the first retry, doubling for every
+	// following retry up to MaxRetryBackoff.
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+
+	// MaxFailedAttempts is the number of consecutive cleanups that failed to
+	// power off and destroy a VM after which the janitor gives up on it.
+	// Zero means never give up.
+	MaxFailedAttempts int
+
+	// PowerOffTimeout and DestroyTimeout bound every attempt to power off
+	// or destroy a VM. Zero means no timeout.
+	PowerOffTimeout time.Duration
+	DestroyTimeout  time.Duration
+
+	// CreationCutoff is the max age of a VM since it was created. When it is
+	// set, VMs that report their creation time are judged by that age alone,
+	// instead of by their uptime or the zero uptime heuristic.
+	CreationCutoff time.Duration
+
+	// SuspendedCutoff is how long a VM may stay suspended before it is
+	// cleaned up. It defaults to Cutoff. Suspended VMs whose suspend time is
+	// unknown are cleaned up like powered off VMs.
+	SuspendedCutoff time.Duration
+
+	// UnregisterBroken makes the janitor unregister VMs whose connection
+	// state has been orphaned, inaccessible or invalid for BrokenCutoff,
+	// since they can't be destroyed. UnregisterDeleteFiles also deletes
+	// their files.
+	UnregisterBroken      bool
+	UnregisterDeleteFiles bool
+	BrokenCutoff          time.Duration
+
+	// PathConcurrency is how many paths CleanupPaths cleans up at the same
+	// time. Zero or one cleans them up one after another.
+	PathConcurrency int
+
+	// GlobalConcurrency limits concurrent power off and destroys across all
+	// paths, on top of the per-path Concurrency. Zero means no global limit.
+	GlobalConcurrency int
+
+	// AdaptiveThrottle, if set, makes the janitor back off its concurrency
+	// and rate when power off and destroy tasks get slow or fail with
+	// transient faults, and recover when they are healthy again.
+	AdaptiveThrottle *AdaptiveThrottleOpts
+
+	// DestroySchedule, if set, limits when VMs may be cleaned up. Outside of
+	// it, cleanups only observe. PathDestroySchedules overrides it for the
+	// paths it has a schedule for.
+	DestroySchedule      *DestroySchedule
+	PathDestroySchedules map[string]*DestroySchedule
+
+	// Order is the order in which VMs are cleaned up, so the most valuable
+	// cleanups happen first when not all of them can. It defaults to
+	// OrderOldest.
+	Order CleanupOrder
+
+	// Pressure, if set, makes the janitor read capacity pressure at the
+	// start of every cleanup, and apply a shorter cutoff while capacity is
+	// low. The VM lister must be a PressureReader.
+	Pressure *PressureOpts
+
+	// Metrics is the registry metrics are reported to, so several janitors
+	// in one process can report separately. It defaults to
+	// metrics.DefaultRegistry.
+	Metrics metrics.Registry
+}
+
+// CleanupPaths cleans up all paths, up to PathConcurrency at the same time.
+// A failing or panicking path doesn't affect the others; errors are returned
+// per path. Afterwards, what was cleaned up in all paths is summarized, and
+// the notifications of all paths are sent in one batch.
+func (j *Janitor) CleanupPaths(ctx context.Context, paths []string, now time.Time) map[string]error {
+	v := j.view()
+	summary := cycleSummary{start: time.Now()}
+	notifications := &cycleNotifications{}
+
+	concurrency := v.opts.PathConcurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	pathSem := make(chan struct{}, concurrency)
+	wg := sync.WaitGroup{}
+	errsMutex := sync.Mutex{}
+	errs := make(map[string]error)
+
+	for _, path := range paths {
+		select {
+		case <-ctx.Done():
+			errs[path] = errors.Wrap(ctx.Err(), "cycle was interrupted before cleaning up path")
+			continue
+		case pathSem <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			defer func() { <-pathSem }()
+
+			result, err := v.cleanupPath(ctx, path, now, notifications)
+			errsMutex.Lock()
+			summary.add(result)
+			if err != nil {
+				errs[path] = err
+			}
+			errsMutex.Unlock()
+		}(path)
+	}
+
+	wg.Wait()
+	summary.paths = len(paths)
+	v.summarizeCycle(ctx, summary)
+	v.sendNotifications(ctx, notifications.flush())
+	return errs
+}
+
+func (j *view) cleanupPath(ctx context.Context, path string, now time.Time, notifications *cycleNotifications) (result PathResult, err error) {
+	metricPath := metricName(path)
+	start := time.Now()
+
+	defer func() {
+		panicErr := recover()
+		if panicErr != nil {
+			err = errors.Errorf("panic while cleaning up path: %v", panicErr)
+		}
+
+		metrics.GetOrRegisterTimer("vsphere.janitor.cleanup.path."+metricPath+".duration", j.metrics).UpdateSince(start)
+		if err != nil {
+			metrics.GetOrRegisterMeter("vsphere.janitor.cleanup.path."+metricPath+".errors", j.metrics).Mark(1)
+		}
+	}()
+
+	return j.cleanup(ctx, path, now, notifications)
+}
+
+// metricName turns an inventory path into something usable as part of a
+// metric name.
+func metricName(path string) string {
+	return strings.Trim(metricNameReplacer.Replace(path), "_")
+}
+
+var metricNameReplacer = strings.NewReplacer("/", "_", " ", "_", ".", "_")
+
+// Validate returns an error describing the first invalid option.
+func (o *JanitorOpts) Validate() error {
+	if o.Cutoff <= 0 {
+		return errors.Errorf("cutoff must be positive, but was %v", o.Cutoff)
+	}
+
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"zero uptime cutoff", o.ZeroUptimeCutoff},
+		{"creation cutoff", o.CreationCutoff},
+		{"suspended cutoff", o.SuspendedCutoff},
+		{"broken cutoff", o.BrokenCutoff},
+		{"retry backoff", o.RetryBackoff},
+		{"max retry backoff", o.MaxRetryBackoff},
+		{"power off timeout", o.PowerOffTimeout},
+		{"destroy timeout", o.DestroyTimeout},
+	}
+	for _, d := range durations {
+		if d.value < 0 {
+			return errors.Errorf("%s must not be negative, but was %v", d.name, d.value)
+		}
+	}
+
+	// an unbuffered queue with no workers would block forever
+	if o.Concurrency < 1 {
+		return errors.Errorf("concurrency must be at least 1, but was %d", o.Concurrency)
+	}
+
+	counts := []struct {
+		name  string
+		value int
+	}{
+		{"path concurrency", o.PathConcurrency},
+		{"global concurrency", o.GlobalConcurrency},
+		{"max destroys per cycle", o.MaxDestroysPerCycle},
+		{"notify failure threshold", o.NotifyFailureThreshold},
+		{"max retries", o.MaxRetries},
+		{"max failed attempts", o.MaxFailedAttempts},
+	}
+	for _, n := range counts {
+		if n.value < 0 {
+			return errors.Errorf("%s must not be negative, but was %d", n.name, n.value)
+		}
+	}
+
+	if o.MaxRetryBackoff > 0 && o.MaxRetryBackoff < o.RetryBackoff {
+		return errors.Errorf("max retry backoff must not be less than retry backoff %v, but was %v", o.RetryBackoff, o.MaxRetryBackoff)
+	}
+
+	if o.UnregisterDeleteFiles && !o.UnregisterBroken {
+		return errors.New("deleting the files of unregistered VMs requires unregistering broken VMs")
+	}
+
+	if o.UnregisterBroken && o.BrokenCutoff <= 0 {
+		return errors.Errorf("unregistering broken VMs requires a positive broken cutoff, but was %v", o.BrokenCutoff)
+	}
+
+	if o.RateLimiter == nil && o.RatePerSecond <= 0 {
+		return errors.Errorf("rate per second must be positive, but was %d", o.RatePerSecond)
+	}
+
+	if o.RateBurst < 0 {
+		return errors.Errorf("rate burst must not be negative, but was %d", o.RateBurst)
+	}
+
+	err := o.Order.validate()
+	if err != nil {
+		return err
+	}
+
+	if o.AdaptiveThrottle != nil {
+		err = o.AdaptiveThrottle.validate()
+		if err != nil {
+			return err
+		}
+	}
+
+	if o.Pressure != nil {
+		return o.Pressure.validate()
+	}
+
+	return nil
+}
+
+// A cleanupDecision is a VM the janitor decided to clean up, on its way
+// through the act and report stages of Cleanup.
+type cleanupDecision struct {
+	vm     VirtualMachine
+	action Action
+	logger logrus.FieldLogger
+	event  *libhoney.Event
+	err    error
+
+	// reclaimed is what cleaning up the VM freed, once it succeeded.
+	reclaimed Resources
+
+	// skipped is why the VM wasn't cleaned up after all, if the path was
+	// paused or its destroy schedule ended after the cleanup started.
+	skipped string
 }
 
+// Why VMs that were about to be cleaned up were skipped.
+const (
+	skippedPaused          = "paused"
+	skippedOutsideSchedule = "outside_schedule"
+)
+
+// Cleanup powers off and destroys the stale VMs in path. It is a pipeline:
+// the VMs are listed and evaluated, a decision is made for each of them in
+// the cleanup order, VMs to clean up are queued for a fixed pool of
+// Concurrency workers, and the outcomes are reported as they come in.
+// Deciding blocks while all workers are busy and the queue is full.
 func (j *Janitor) Cleanup(ctx context.Context, path string, now time.Time) error {
+	v := j.view()
+
+	notifications := &cycleNotifications{}
+	_, err := v.cleanup(ctx, path, now, notifications)
+	v.sendNotifications(ctx, notifications.flush())
+	return err
+}
+
+// cleanup cleans up path unless it is paused, and records and returns the
+// result. Notifications are added to notifications, to be sent by the caller.
+func (j *view) cleanup(ctx context.Context, path string, now time.Time, notifications *cycleNotifications) (PathResult, error) {
+	result := PathResult{Path: path, Start: time.Now()}
+
+	if j.pathPaused(path) {
+		log.WithContext(ctx).WithField("path", path).Info("janitor is paused, skipping path")
+		metrics.GetOrRegisterMeter("vsphere.janitor.cleanup.paused", j.metrics).Mark(1)
+
+		result.Paused = true
+		j.recordResult(result)
+		return result, nil
+	}
+
+	err := j.cleanupVMs(ctx, path, now, &result, notifications)
+	result.Duration = time.Since(result.Start)
+	if err != nil {
+		result.Err = err.Error()
+	}
+	j.recordResult(result)
+	j.summarize(ctx, result)
+
+	return result, err
+}
+
+func (j *view) cleanupVMs(ctx context.Context, path string, now time.Time, result *PathResult, notifications *cycleNotifications) error {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	sem := make(chan struct{}, j.opts.Concurrency)
-	wg := sync.WaitGroup{}
-	throttle := time.NewTicker(time.Second / time.Duration(j.opts.RatePerSecond))
-	defer throttle.Stop()
+	ctx = WithRateLimiter(ctx, j.rateLimiter)
 
 	vms, err := j.vmLister.ListVMs(ctx, path)
 	if err != nil {
 		return errors.Wrap(err, "couldn't list VMs")
 	}
 
+	result.VMs = len(vms)
+	cycle := newCleanupCycle(path, j.opts.MaxDestroysPerCycle)
+	cycle.now, cycle.started = now, time.Now()
+	cycle.observeOnly = !j.destroyAllowed(path, now)
+	result.ObserveOnly = cycle.observeOnly
+
+	allowed := int64(1)
+	if cycle.observeOnly {
+		allowed = 0
+		log.WithContext(ctx).WithField("path", path).Info("outside of destroy schedule, only observing")
+	}
+	metrics.GetOrRegisterGauge("vsphere.janitor.cleanup.path."+metricName(path)+".destroy_allowed", j.metrics).Update(allowed)
+
+	cycle.cutoffs = j.cutoffs(ctx, path)
+	result.PressureMode = cycle.cutoffs.pressure != ""
+	if result.PressureMode {
+		log.WithContext(ctx).WithField("path", path).WithField("cutoff", cycle.cutoffs.uptime).Info("capacity is low, cleaning up aggressively: " + cycle.cutoffs.pressure)
+	}
+
+	j.updateConnectionStateMetrics(vms)
+	j.updateProtectionMetrics(vms)
+
+	workers := j.opts.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	queue := make(chan *cleanupDecision, workers)
+	results := make(chan *cleanupDecision, workers)
+
+	workersWG := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		workersWG.Add(1)
+		go func() {
+			defer workersWG.Done()
+			for decision := range queue {
+				decision.err = j.act(ctx, cycle, decision)
+				results <- decision
+			}
+		}()
+	}
+
+	reportDone := make(chan struct{})
+	go func() {
+		defer close(reportDone)
+		for decision := range results {
+			j.report(ctx, cycle, decision)
+
+			switch {
+			case decision.skipped != "":
+				result.Skipped++
+			case decision.err != nil:
+				result.Failed++
+			case decision.action == ActionUnregister:
+				result.Unregistered++
+			default:
+				result.Destroyed++
+			}
+			result.Reclaimed.add(decision.reclaimed)
+		}
+	}()
+
+	verdicts := make([]*Verdict, 0, len(vms))
 	for _, vm := range vms {
-		<-throttle.C
+		verdict, err := j.evaluateVM(vm, now, cycle.cutoffs)
+		if err != nil {
+			log.WithContext(ctx).WithError(err).Error("error evaluating VM")
+			continue
+		}
+		verdicts = append(verdicts, verdict)
+	}
+	sortVerdicts(verdicts, j.opts.Order)
+
+	for _, verdict := range verdicts {
+		if ctx.Err() != nil {
+			break
+		}
 
-		err := j.handleVM(ctx, vm, &wg, sem, now)
+		decision, err := j.decide(ctx, verdict, cycle, now)
 		if err != nil {
 			log.WithContext(ctx).WithError(err).Error("error handling VM")
+			continue
+		}
+
+		if decision != nil {
+			queue <- decision
 		}
 	}
 
+	close(queue)
+	workersWG.Wait()
+	close(results)
+	<-reportDone
+
 	j.cleanupFirstSeen(vms)
+	j.cleanupFailures(vms)
 
-	wg.Wait()
+	notifications.add(cycle.flush())
+	j.updateFailureMetrics()
+
+	metrics.GetOrRegisterGauge("vsphere.janitor.cleanup.vms.total", j.metrics).Update(int64(len(vms)))
 
-	metrics.GetOrRegisterGauge("vsphere.janitor.cleanup.vms.total", metrics.DefaultRegistry).Update(int64(len(vms)))
+	if ctx.Err() != nil {
+		return errors.Wrap(ctx.Err(), "cleanup was interrupted")
+	}
 	return nil
 }
 
-func (j *Janitor) cleanupFirstSeen(vms []VirtualMachine) {
-	j.zeroUptimeFirstSeenMutex.Lock()
-	defer j.zeroUptimeFirstSeenMutex.Unlock()
+// protection returns why a VM must never be cleaned up regardless of
+// policy, or an empty string if it may be.
+func protection(vm VirtualMachine) string {
+	switch {
+	case vm.Template():
+		return "template"
+	case vm.LinkedCloneParent():
+		return "linked_clone_parent"
+	default:
+		return ""
+	}
+}
 
+func (j *Janitor) updateProtectionMetrics(vms []VirtualMachine) {
+	counts := map[string]int64{
+		"template":            0,
+		"linked_clone_parent": 0,
+	}
+	for _, vm := range vms {
+		if protection := protection(vm); protection != "" {
+			counts[protection]++
+		}
+	}
+
+	for protection, count := range counts {
+		metrics.GetOrRegisterGauge("vsphere.janitor.cleanup.vms.protected."+protection, j.metrics).Update(count)
+	}
+}
+
+func (j *Janitor) updateConnectionStateMetrics(vms []VirtualMachine) {
+	counts := map[ConnectionState]int64{
+		ConnectionStateDisconnected: 0,
+		ConnectionStateOrphaned:     0,
+		ConnectionStateInaccessible: 0,
+		ConnectionStateInvalid:      0,
+	}
+	for _, vm := range vms {
+		if state := vm.ConnectionState(); state != ConnectionStateConnected {
+			counts[state]++
+		}
+	}
+
+	for state, count := range counts {
+		metrics.GetOrRegisterGauge("vsphere.janitor.cleanup.vms.connection_state."+string(state), j.metrics).Update(count)
+	}
+}
+
+func (j *Janitor) cleanupFirstSeen(vms []VirtualMachine) {
 	vmExists := make(map[string]bool, len(vms))
+	brokenVMExists := make(map[string]bool, len(vms))
 	for _, vm := range vms {
 		vmExists[vm.ID()] = true
+		if vm.ConnectionState().Broken() {
+			brokenVMExists[brokenKey(vm)] = true
+		}
 	}
 
+	j.zeroUptimeFirstSeenMutex.Lock()
 	for id := range j.zeroUptimeFirstSeen {
 		if !vmExists[id] {
 			delete(j.zeroUptimeFirstSeen, id)
 		}
 	}
+	j.zeroUptimeFirstSeenMutex.Unlock()
+
+	// VMs that are gone or aren't broken anymore have to be seen broken
+	// for the whole cutoff again
+	j.brokenFirstSeenMutex.Lock()
+	for key := range j.brokenFirstSeen {
+		if !brokenVMExists[key] {
+			delete(j.brokenFirstSeen, key)
+		}
+	}
+	j.brokenFirstSeenMutex.Unlock()
+}
+
+func (j *Janitor) cleanupFailures(vms []VirtualMachine) {
+	j.failuresMutex.Lock()
+	defer j.failuresMutex.Unlock()
+
+	vmExists := make(map[string]bool, len(vms))
+	for _, vm := range vms {
+		vmExists[vm.ID()] = true
+	}
+
+	for id := range j.failures {
+		if !vmExists[id] {
+			delete(j.failures, id)
+		}
+	}
+}
+
+// sendNotifications queues notifications to be sent in the background in
+// one batch. If too many are waiting to be sent already, they are dropped.
+func (j *view) sendNotifications(ctx context.Context, notifications []Notification) {
+	if j.opts.Notifier == nil || len(notifications) == 0 {
+		return
+	}
+
+	logger := log.WithContext(ctx).WithField("count", len(notifications))
+	ok := j.notifications.push(notificationBatch{
+		notifier:      j.opts.Notifier,
+		notifications: notifications,
+		logger:        logger,
+	})
+	if !ok {
+		logger.Error("too many notifications waiting to be sent, dropping them")
+		metrics.GetOrRegisterMeter("vsphere.janitor.notifications.dropped", j.metrics).Mark(int64(len(notifications)))
+	}
+}
+
+func (j *Janitor) sendNotificationBatch(batch notificationBatch) {
+	err := batch.notifier.Notify(context.Background(), batch.notifications)
+	if err != nil {
+		batch.logger.WithError(err).Error("error sending notifications")
+		metrics.GetOrRegisterMeter("vsphere.janitor.notifications.errors", j.metrics).Mark(1)
+		return
+	}
+
+	metrics.GetOrRegisterMeter("vsphere.janitor.notifications.sent", j.metrics).Mark(int64(len(batch.notifications)))
+}
+
+// WaitForNotifications waits until the notifications of finished cleanups
+// have been sent, or ctx is done.
+func (j *Janitor) WaitForNotifications(ctx context.Context) error {
+	return j.notifications.wait(ctx)
+}
+
+// evaluateVM evaluates vm during a cleanup, remembering it if it has zero
+// uptime, and turns a panic into an error.
+func (j *view) evaluateVM(vm VirtualMachine, now time.Time, cutoffs cutoffs) (verdict *Verdict, err error) {
+	defer func() {
+		panicErr := recover()
+		if panicErr != nil {
+			err = panicErr.(error)
+		}
+	}()
+
+	return j.evaluate(vm, now, cutoffs, true), nil
 }
 
-func (j *Janitor) handleVM(ctx context.Context,
-	vm VirtualMachine, wg *sync.WaitGroup, sem chan (struct{}), now time.Time) (err error) {
+// decide returns a decision to clean up the VM of verdict, or nil if it
+// should be left alone for now.
+func (j *view) decide(ctx context.Context, verdict *Verdict, cycle *cleanupCycle, now time.Time) (decision *cleanupDecision, err error) {
+	vm := verdict.VM
 	logger := log.WithContext(ctx).WithField("vm", vm.Name())
 	event := libhoney.NewEvent()
 	event.AddField("meta.type", "cleanup")
 	event.AddField("app.vm_id", vm.ID())
 	event.AddField("app.vm_name", vm.Name())
 	event.AddField("app.powered_on", vm.PoweredOn())
+	event.AddField("app.power_state", string(vm.PowerState()))
+	event.AddField("app.connection_state", string(vm.ConnectionState()))
+	event.AddField("app.protection", protection(vm))
+	addResourceFields(event, vm)
+	event.AddField("app.pressure_mode", cycle.cutoffs.pressure != "")
+	event.AddField("app.cutoff", cycle.cutoffs.uptime/time.Second)
 
 	defer func() {
 		panicErr := recover()
@@ -109,84 +876,164 @@ func (j *Janitor) handleVM(ctx context.Context,
 		}
 	}()
 
-	uptimeSecs := int(vm.Uptime().Seconds())
+	for name, age := range verdict.Ages {
+		logger = logger.WithField(name, age)
+		event.AddField("app."+name, age/time.Second)
+	}
 
-	if uptimeSecs == 0 && vm.BootTime() == nil {
-		if vm.ID() == "" {
-			logger.Info("VM doesn't have ID yet, skipping")
-			return nil
-		}
+	if verdict.Action == ActionSkip {
+		logger.Info(verdict.Reason + ", skipping")
+		return nil, nil
+	}
 
-		firstSeen, ok := j.getZeroUptimeFirstSeen(vm.ID())
-		if !ok || now.Sub(firstSeen) < j.opts.ZeroUptimeCutoff {
-			logger.Info("instance has 0 uptime, skipping for now")
-			if !ok {
-				j.setZeroUptimeFirstSeen(vm.ID(), now)
-			}
-			return nil
-		}
+	if cycle.observeOnly {
+		logger.WithField("action", verdict.Action).Info(verdict.Reason + ", but only observing")
+		metrics.GetOrRegisterMeter("vsphere.janitor.cleanup.vms.observed", j.metrics).Mark(1)
+		return nil, nil
+	}
 
-		event.AddField("app.since_first_seen", time.Since(firstSeen))
-		j.deleteZeroUptimeFirstSeen(vm.ID())
-		logger.WithField("since_first_seen", time.Since(firstSeen)).Info("instance has had 0 uptime for more than timeout, destroying")
-	} else {
-		bootTime := vm.BootTime()
+	logger.WithField("action", verdict.Action).Info(verdict.Reason)
 
-		if j.opts.SkipNoBootTime && bootTime == nil {
-			logger.Info("instance has no boot time, skipping")
-			return nil
-		}
+	decision = &cleanupDecision{vm: vm, action: verdict.Action, logger: logger, event: event}
+	if j.pathPaused(cycle.path) {
+		decision.skipped = skippedPaused
+		return decision, nil
+	}
+
+	if !cycle.reserveDestroy(now) {
+		logger.Warn("reached max destroys per cycle, skipping instance")
+		metrics.GetOrRegisterMeter("vsphere.janitor.cleanup.vms.safety_limit", j.metrics).Mark(1)
+		return nil, nil
+	}
+
+	// the VM is being cleaned up, so it doesn't need tracking anymore
+	j.deleteZeroUptimeFirstSeen(vm.ID())
+
+	return decision, nil
+}
 
-		if bootTime != nil {
-			bootedAgo := now.UTC().Sub(*bootTime)
-			event.AddField("app.since_boot", bootedAgo/time.Second)
-			logger.WithField("booted_ago", bootedAgo).Info("time since instance boot")
+// report logs and records the outcome of a decision that was acted upon.
+func (j *view) report(ctx context.Context, cycle *cleanupCycle, decision *cleanupDecision) {
+	switch decision.skipped {
+	case skippedPaused:
+		decision.logger.Info("janitor was paused, skipping instance")
+		metrics.GetOrRegisterMeter("vsphere.janitor.cleanup.vms.paused", j.metrics).Mark(1)
+		return
+	case skippedOutsideSchedule:
+		decision.logger.Info("destroy schedule ended, only observing instance")
+		metrics.GetOrRegisterMeter("vsphere.janitor.cleanup.vms.observed", j.metrics).Mark(1)
+		return
+	}
+
+	if decision.err != nil {
+		decision.event.AddField("app.err", decision.err.Error())
+		decision.logger.WithError(decision.err).Error("error powering off and destroying instance")
+
+		// an interrupted cleanup isn't the VM's fault
+		if ctx.Err() == nil {
+			j.recordFailure(decision.logger, cycle, decision.vm, decision.err)
 		}
+	} else {
+		j.clearFailures(decision.vm.ID())
+		decision.reclaimed = j.reclaimed(decision.vm, decision.action, cycle.cutoffs.uptime)
+		j.recordReclaimed(cycle.path, decision.reclaimed, decision.event)
+	}
 
-		uptime := time.Duration(uptimeSecs) * time.Second
-		event.AddField("app.uptime", uptimeSecs)
-		if uptime < j.opts.Cutoff && vm.PoweredOn() {
-			logger.WithField("uptime", uptime).WithField("powered_on", vm.PoweredOn()).Info("skipping instance")
-			return nil
+	// only send events if we actually cleaned up the VM
+	decision.event.Send()
+}
+
+// act carries out a decision, within the global concurrency limits. Unless
+// the VM is destroyed on request, it is skipped if its path was paused or its
+// destroy schedule ended while the decision waited.
+func (j *view) act(ctx context.Context, cycle *cleanupCycle, decision *cleanupDecision) (err error) {
+	if decision.skipped != "" {
+		return nil
+	}
+
+	if j.globalSem != nil {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case j.globalSem <- struct{}{}:
 		}
+		defer func() { <-j.globalSem }()
 	}
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		err := j.powerOffAndDestroy(ctx, logger, sem, vm)
+	if j.throttle != nil {
+		err := j.throttle.Acquire(ctx)
 		if err != nil {
-			event.AddField("app.err", err.Error())
-			logger.WithError(err).Error("error powering off and destroying instance")
+			return err
 		}
+		defer j.throttle.Release()
+	}
 
-		// only send events if we actually cleaned up the VM
-		event.Send()
-	}()
-	return nil
-}
+	if !cycle.manual {
+		switch {
+		case j.pathPaused(cycle.path):
+			decision.skipped = skippedPaused
+			return nil
+		case !j.destroyAllowed(cycle.path, cycle.clock()):
+			decision.skipped = skippedOutsideSchedule
+			return nil
+		}
+	}
 
-func (j *Janitor) powerOffAndDestroy(ctx context.Context, logger logrus.FieldLogger, sem chan (struct{}), vm VirtualMachine) (err error) {
-	sem <- struct{}{}
 	defer func() {
 		panicErr := recover()
 		if panicErr != nil {
 			err = panicErr.(error)
 		}
-		<-sem
 	}()
 
+	switch decision.action {
+	case ActionUnregister:
+		return j.unregister(ctx, decision.logger, decision.vm)
+	default:
+		return j.powerOffAndDestroy(ctx, decision.logger, cycle, decision.vm)
+	}
+}
+
+func (j *view) unregister(ctx context.Context, logger logrus.FieldLogger, vm VirtualMachine) error {
+	if j.opts.SkipDestroy {
+		logger.Info("skipping unregister step")
+		return nil
+	}
+
+	logger.WithField("delete_files", j.opts.UnregisterDeleteFiles).Info("unregistering instance")
+
+	err := j.retry(ctx, logger, "unregister", j.opts.DestroyTimeout, func(ctx context.Context) error {
+		return vm.Unregister(ctx, j.opts.UnregisterDeleteFiles)
+	})
+	if err != nil {
+		return errors.Wrap(err, "error unregistering VM")
+	}
+
+	logger.Info("unregistered instance")
+	metrics.GetOrRegisterMeter("vsphere.janitor.cleanup.vms.unregister", j.metrics).Mark(1)
+
+	return nil
+}
+
+func (j *view) powerOffAndDestroy(ctx context.Context, logger logrus.FieldLogger, cycle *cleanupCycle, vm VirtualMachine) error {
 	logger.WithField("uptime", vm.Uptime()).Info("handling poweroff and destroy of instance")
 
-	if vm.PoweredOn() {
-		logger.Info("powering off instance")
+	// suspended VMs are powered off as well, throwing away their state,
+	// rather than relying on vSphere to destroy them as they are
+	powerState := vm.PowerState()
+	poweredOn := powerState == PowerStateOn
+	if poweredOn || powerState == PowerStateSuspended {
+		logger.WithField("power_state", powerState).Info("powering off instance")
 
-		err := vm.PowerOff(ctx)
+		err := j.retry(ctx, logger, "power off", j.opts.PowerOffTimeout, vm.PowerOff)
 		if err != nil {
+			if IsTimeout(err) {
+				metrics.GetOrRegisterMeter("vsphere.janitor.cleanup.vms.poweroff.timeout", j.metrics).Mark(1)
+			}
 			return errors.Wrap(err, "error powering off VM")
 		}
 
-		metrics.GetOrRegisterMeter("vsphere.janitor.cleanup.vms.poweroff", metrics.DefaultRegistry).Mark(1)
+		metrics.GetOrRegisterMeter("vsphere.janitor.cleanup.vms.poweroff", j.metrics).Mark(1)
 	}
 
 	if j.opts.SkipDestroy {
@@ -196,17 +1043,35 @@ func (j *Janitor) powerOffAndDestroy(ctx context.Context, logger logrus.FieldLog
 
 	logger.Info("destroying instance")
 
-	err = vm.Destroy(ctx)
+	err := j.retry(ctx, logger, "destroy", j.opts.DestroyTimeout, vm.Destroy)
 	if err != nil {
+		if IsTimeout(err) {
+			metrics.GetOrRegisterMeter("vsphere.janitor.cleanup.vms.destroy.timeout", j.metrics).Mark(1)
+		}
 		return errors.Wrap(err, "error destroying VM")
 	}
 
 	logger.Info("destroyed instance")
-	metrics.GetOrRegisterMeter("vsphere.janitor.cleanup.vms.destroy", metrics.DefaultRegistry).Mark(1)
+	metrics.GetOrRegisterMeter("vsphere.janitor.cleanup.vms.destroy", j.metrics).Mark(1)
+
+	if poweredOn {
+		cycle.notify(NotificationPoweredOnDestroyed, vm, "destroyed a VM that was powered on", nil)
+	}
 
 	return nil
 }
 
+// destroyAllowed returns true if the destroy schedule of path allows
+// cleaning up VMs at now.
+func (j *view) destroyAllowed(path string, now time.Time) bool {
+	schedule := j.opts.DestroySchedule
+	if pathSchedule, ok := j.opts.PathDestroySchedules[path]; ok {
+		schedule = pathSchedule
+	}
+
+	return schedule == nil || schedule.DestroyAllowed(now)
+}
+
 func (j *Janitor) getZeroUptimeFirstSeen(id string) (time.Time, bool) {
 	j.zeroUptimeFirstSeenMutex.Lock()
 	defer j.zeroUptimeFirstSeenMutex.Unlock()
@@ -225,3 +1090,77 @@ func (j *Janitor) deleteZeroUptimeFirstSeen(id string) {
 	defer j.zeroUptimeFirstSeenMutex.Unlock()
 	delete(j.zeroUptimeFirstSeen, id)
 }
+
+// brokenKey identifies a broken VM between cycles. Broken VMs often have no
+// configuration to read their ID from, so their name is used instead.
+func brokenKey(vm VirtualMachine) string {
+	if vm.ID() != "" {
+		return vm.ID()
+	}
+	return "name:" + vm.Name()
+}
+
+func (j *Janitor) getBrokenFirstSeen(key string) (time.Time, bool) {
+	j.brokenFirstSeenMutex.Lock()
+	defer j.brokenFirstSeenMutex.Unlock()
+	firstSeen, ok := j.brokenFirstSeen[key]
+	return firstSeen, ok
+}
+
+func (j *Janitor) setBrokenFirstSeen(key string, now time.Time) {
+	j.brokenFirstSeenMutex.Lock()
+	defer j.brokenFirstSeenMutex.Unlock()
+	j.brokenFirstSeen[key] = now
+}
+
+// recordFailure counts a failure to power off and destroy a VM, records a
+// notification when the number of consecutive failures reaches the threshold,
+// and gives up on the VM once it reaches MaxFailedAttempts.
+func (j *view) recordFailure(logger logrus.FieldLogger, cycle *cleanupCycle, vm VirtualMachine, err error) {
+	j.failuresMutex.Lock()
+	j.failures[vm.ID()]++
+	count := j.failures[vm.ID()]
+	j.failuresMutex.Unlock()
+
+	if j.opts.NotifyFailureThreshold > 0 && count == j.opts.NotifyFailureThreshold {
+		cycle.notify(NotificationRepeatedFailure, vm, fmt.Sprintf("failed to power off and destroy VM %d times in a row", count), err)
+	}
+
+	if j.opts.MaxFailedAttempts > 0 && count == j.opts.MaxFailedAttempts {
+		logger.WithField("failures", count).Error("giving up on instance")
+		metrics.GetOrRegisterMeter("vsphere.janitor.cleanup.vms.give_up", j.metrics).Mark(1)
+	}
+}
+
+// givenUp returns true if powering off and destroying the VM has failed
+// MaxFailedAttempts times in a row.
+func (j *view) givenUp(id string) bool {
+	if j.opts.MaxFailedAttempts <= 0 {
+		return false
+	}
+
+	j.failuresMutex.Lock()
+	defer j.failuresMutex.Unlock()
+	return j.failures[id] >= j.opts.MaxFailedAttempts
+}
+
+func (j *view) updateFailureMetrics() {
+	j.failuresMutex.Lock()
+	defer j.failuresMutex.Unlock()
+
+	givenUp := 0
+	for _, count := range j.failures {
+		if j.opts.MaxFailedAttempts > 0 && count >= j.opts.MaxFailedAttempts {
+			givenUp++
+		}
+	}
+
+	metrics.GetOrRegisterGauge("vsphere.janitor.cleanup.vms.failing", j.metrics).Update(int64(len(j.failures)))
+	metrics.GetOrRegisterGauge("vsphere.janitor.cleanup.vms.given_up", j.metrics).Update(int64(givenUp))
+}
+
+func (j *Janitor) clearFailures(id string) {
+	j.failuresMutex.Lock()
+	defer j.failuresMutex.Unlock()
+	delete(j.failures, id)
+}
@@ -2,9 +2,12 @@ package vspherejanitor_test
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"testing"
 	"time"
 
+	metrics "github.com/rcrowley/go-metrics"
 	vspherejanitor "github.com/travis-ci/vsphere-janitor"
 	"github.com/travis-ci/vsphere-janitor/mock"
 )
@@ -70,6 +73,611 @@ func TestJanitor(t *testing.T) {
 	}
 }
 
+func TestJanitorNotifications(t *testing.T) {
+	vmLister := mock.NewVMLister(map[string][]*mock.VMData{
+		"/": {
+			{
+				Name:      "old-powered-on",
+				Uptime:    2 * time.Hour,
+				BootTime:  timePointer(aTime.Add(-2 * time.Hour)),
+				PoweredOn: true,
+			},
+			{
+				Name:       "stuck",
+				Uptime:     2 * time.Hour,
+				BootTime:   timePointer(aTime.Add(-2 * time.Hour)),
+				DestroyErr: errors.New("destroy failed"),
+			},
+		},
+	})
+	notifier := &mock.Notifier{}
+
+	janitor := vspherejanitor.NewJanitor(vmLister, &vspherejanitor.JanitorOpts{
+		Cutoff:                 time.Hour,
+		Concurrency:            1,
+		RatePerSecond:          100,
+		SkipNoBootTime:         true,
+		Notifier:               notifier,
+		NotifyFailureThreshold: 2,
+	})
+
+	err := janitor.Cleanup(context.TODO(), "/", aTime)
+	assertOk(t, "janitor.Cleanup(/)", err)
+	assertOk(t, "janitor.WaitForNotifications()", janitor.WaitForNotifications(context.TODO()))
+	assertEqual(t, "len(notifier.Batches()) after first cleanup", 1, len(notifier.Batches()))
+	assertEqual(t, "notifier.Kinds() after first cleanup", "[powered_on_destroyed]", fmt.Sprint(notifier.Kinds()))
+
+	err = janitor.Cleanup(context.TODO(), "/", aTime)
+	assertOk(t, "janitor.Cleanup(/)", err)
+	assertOk(t, "janitor.WaitForNotifications()", janitor.WaitForNotifications(context.TODO()))
+	assertEqual(t, "len(notifier.Batches()) after second cleanup", 2, len(notifier.Batches()))
+
+	repeatedFailures := []string{}
+	for _, notification := range notifier.Batches()[1] {
+		if notification.Kind == vspherejanitor.NotificationRepeatedFailure {
+			repeatedFailures = append(repeatedFailures, notification.VMName)
+		}
+	}
+	assertEqual(t, "repeated failure notifications", "[stuck]", fmt.Sprint(repeatedFailures))
+}
+
+func TestJanitorNotificationsPerCycle(t *testing.T) {
+	vm := func(name string) *mock.VMData {
+		return &mock.VMData{
+			Name:      name,
+			Uptime:    2 * time.Hour,
+			BootTime:  timePointer(aTime.Add(-2 * time.Hour)),
+			PoweredOn: true,
+		}
+	}
+	vmLister := mock.NewVMLister(map[string][]*mock.VMData{
+		"/dc1": {vm("dc1-vm")},
+		"/dc2": {vm("dc2-vm")},
+	})
+	notifier := &mock.Notifier{}
+
+	janitor := vspherejanitor.NewJanitor(vmLister, &vspherejanitor.JanitorOpts{
+		Cutoff:          time.Hour,
+		Concurrency:     1,
+		PathConcurrency: 2,
+		RatePerSecond:   100,
+		SkipNoBootTime:  true,
+		Notifier:        notifier,
+	})
+
+	errs := janitor.CleanupPaths(context.TODO(), []string{"/dc1", "/dc2"}, aTime)
+	assertEqual(t, "len(errs)", 0, len(errs))
+	assertOk(t, "janitor.WaitForNotifications()", janitor.WaitForNotifications(context.TODO()))
+	assertEqual(t, "len(notifier.Batches())", 1, len(notifier.Batches()))
+	assertEqual(t, "len(notifier.Batches()[0])", 2, len(notifier.Batches()[0]))
+}
+
+func TestJanitorMaxDestroysPerCycle(t *testing.T) {
+	vms := []*mock.VMData{}
+	for _, name := range []string{"vm-1", "vm-2", "vm-3"} {
+		vms = append(vms, &mock.VMData{
+			Name:     name,
+			Uptime:   2 * time.Hour,
+			BootTime: timePointer(aTime.Add(-2 * time.Hour)),
+		})
+	}
+	vmLister := mock.NewVMLister(map[string][]*mock.VMData{"/": vms})
+	notifier := &mock.Notifier{}
+
+	janitor := vspherejanitor.NewJanitor(vmLister, &vspherejanitor.JanitorOpts{
+		Cutoff:              time.Hour,
+		Concurrency:         1,
+		RatePerSecond:       100,
+		SkipNoBootTime:      true,
+		MaxDestroysPerCycle: 2,
+		Notifier:            notifier,
+	})
+
+	err := janitor.Cleanup(context.TODO(), "/", aTime)
+	assertOk(t, "janitor.Cleanup(/)", err)
+
+	assertEqual(t, `Destroyed("/", "vm-1")`, true, vmLister.Destroyed("/", "vm-1"))
+	assertEqual(t, `Destroyed("/", "vm-2")`, true, vmLister.Destroyed("/", "vm-2"))
+	assertEqual(t, `Destroyed("/", "vm-3")`, false, vmLister.Destroyed("/", "vm-3"))
+	assertOk(t, "janitor.WaitForNotifications()", janitor.WaitForNotifications(context.TODO()))
+	assertEqual(t, "notifier.Kinds()", "[safety_limit]", fmt.Sprint(notifier.Kinds()))
+}
+
+type transientError struct{}
+
+func (transientError) Error() string   { return "task in progress" }
+func (transientError) Temporary() bool { return true }
+
+func TestJanitorRetriesTransientErrors(t *testing.T) {
+	vmLister := mock.NewVMLister(map[string][]*mock.VMData{
+		"/": {
+			{
+				Name:            "flaky",
+				Uptime:          2 * time.Hour,
+				BootTime:        timePointer(aTime.Add(-2 * time.Hour)),
+				DestroyErr:      transientError{},
+				DestroyErrCount: 2,
+			},
+		},
+	})
+
+	janitor := vspherejanitor.NewJanitor(vmLister, &vspherejanitor.JanitorOpts{
+		Cutoff:         time.Hour,
+		Concurrency:    1,
+		RatePerSecond:  100,
+		SkipNoBootTime: true,
+		MaxRetries:     2,
+		RetryBackoff:   time.Millisecond,
+	})
+
+	err := janitor.Cleanup(context.TODO(), "/", aTime)
+	assertOk(t, "janitor.Cleanup(/)", err)
+	assertEqual(t, `Destroyed("/", "flaky")`, true, vmLister.Destroyed("/", "flaky"))
+}
+
+func TestJanitorGivesUpAfterMaxFailedAttempts(t *testing.T) {
+	vmData := &mock.VMData{
+		Name:       "stuck",
+		Uptime:     2 * time.Hour,
+		BootTime:   timePointer(aTime.Add(-2 * time.Hour)),
+		DestroyErr: errors.New("destroy failed"),
+	}
+	vmLister := mock.NewVMLister(map[string][]*mock.VMData{"/": {vmData}})
+
+	janitor := vspherejanitor.NewJanitor(vmLister, &vspherejanitor.JanitorOpts{
+		Cutoff:            time.Hour,
+		Concurrency:       1,
+		RatePerSecond:     100,
+		SkipNoBootTime:    true,
+		MaxRetries:        3,
+		RetryBackoff:      time.Millisecond,
+		MaxFailedAttempts: 2,
+	})
+
+	for i := 0; i < 2; i++ {
+		err := janitor.Cleanup(context.TODO(), "/", aTime)
+		assertOk(t, "janitor.Cleanup(/)", err)
+	}
+
+	vmData.DestroyErr = nil
+
+	err := janitor.Cleanup(context.TODO(), "/", aTime)
+	assertOk(t, "janitor.Cleanup(/)", err)
+	assertEqual(t, `Destroyed("/", "stuck")`, false, vmLister.Destroyed("/", "stuck"))
+}
+
+func TestJanitorDestroyTimeout(t *testing.T) {
+	vmLister := mock.NewVMLister(map[string][]*mock.VMData{
+		"/": {
+			{
+				Name:         "hung",
+				Uptime:       2 * time.Hour,
+				BootTime:     timePointer(aTime.Add(-2 * time.Hour)),
+				DestroyDelay: time.Minute,
+			},
+		},
+	})
+	notifier := &mock.Notifier{}
+
+	janitor := vspherejanitor.NewJanitor(vmLister, &vspherejanitor.JanitorOpts{
+		Cutoff:                 time.Hour,
+		Concurrency:            1,
+		RatePerSecond:          100,
+		SkipNoBootTime:         true,
+		MaxRetries:             3,
+		RetryBackoff:           time.Millisecond,
+		DestroyTimeout:         10 * time.Millisecond,
+		Notifier:               notifier,
+		NotifyFailureThreshold: 1,
+	})
+
+	start := time.Now()
+	err := janitor.Cleanup(context.TODO(), "/", aTime)
+	assertOk(t, "janitor.Cleanup(/)", err)
+
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("Cleanup took %v, expected it to time out quickly", time.Since(start))
+	}
+	assertEqual(t, `Destroyed("/", "hung")`, false, vmLister.Destroyed("/", "hung"))
+	assertOk(t, "janitor.WaitForNotifications()", janitor.WaitForNotifications(context.TODO()))
+	assertEqual(t, "notifier.Kinds()", "[repeated_failure]", fmt.Sprint(notifier.Kinds()))
+}
+
+func TestJanitorSlowNotifier(t *testing.T) {
+	vmLister := mock.NewVMLister(map[string][]*mock.VMData{
+		"/": {
+			{
+				Name:      "running",
+				Uptime:    2 * time.Hour,
+				BootTime:  timePointer(aTime.Add(-2 * time.Hour)),
+				PoweredOn: true,
+			},
+		},
+	})
+	notifier := &mock.Notifier{Block: make(chan struct{})}
+
+	janitor := vspherejanitor.NewJanitor(vmLister, &vspherejanitor.JanitorOpts{
+		Cutoff:         time.Hour,
+		Concurrency:    1,
+		RatePerSecond:  100,
+		SkipNoBootTime: true,
+		Notifier:       notifier,
+	})
+
+	err := janitor.Cleanup(context.TODO(), "/", aTime)
+	assertOk(t, "janitor.Cleanup(/)", err)
+	assertEqual(t, "len(notifier.Batches()) while notifier is blocked", 0, len(notifier.Batches()))
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 10*time.Millisecond)
+	defer cancel()
+	assertError(t, "janitor.WaitForNotifications() while notifier is blocked", janitor.WaitForNotifications(ctx))
+
+	err = janitor.SetOpts(context.TODO(), &vspherejanitor.JanitorOpts{
+		Cutoff:        2 * time.Hour,
+		Concurrency:   1,
+		RatePerSecond: 100,
+		Notifier:      notifier,
+	})
+	assertOk(t, "janitor.SetOpts() while notifier is blocked", err)
+
+	close(notifier.Block)
+	assertOk(t, "janitor.WaitForNotifications()", janitor.WaitForNotifications(context.TODO()))
+	assertEqual(t, "notifier.Kinds()", "[powered_on_destroyed]", fmt.Sprint(notifier.Kinds()))
+}
+
+func TestIsTimeout(t *testing.T) {
+	err := &vspherejanitor.TimeoutError{Op: "destroy", Duration: time.Second, Err: context.DeadlineExceeded}
+	assertEqual(t, "IsTimeout(TimeoutError)", true, vspherejanitor.IsTimeout(err))
+	assertEqual(t, "IsTimeout(other)", false, vspherejanitor.IsTimeout(errors.New("other")))
+	assertEqual(t, "IsTransient(TimeoutError)", false, vspherejanitor.IsTransient(err))
+}
+
+func TestJanitorCleanupPaths(t *testing.T) {
+	oldVM := func(name string) *mock.VMData {
+		return &mock.VMData{
+			Name:     name,
+			Uptime:   2 * time.Hour,
+			BootTime: timePointer(aTime.Add(-2 * time.Hour)),
+		}
+	}
+	vmLister := mock.NewVMLister(map[string][]*mock.VMData{
+		"/dc1": {oldVM("dc1-vm")},
+		"/dc2": {oldVM("dc2-vm")},
+	})
+
+	janitor := vspherejanitor.NewJanitor(vmLister, &vspherejanitor.JanitorOpts{
+		Cutoff:            time.Hour,
+		Concurrency:       2,
+		RatePerSecond:     100,
+		SkipNoBootTime:    true,
+		PathConcurrency:   3,
+		GlobalConcurrency: 1,
+	})
+
+	errs := janitor.CleanupPaths(context.TODO(), []string{"/dc1", "/does-not-exist", "/dc2"}, aTime)
+
+	assertEqual(t, "len(errs)", 1, len(errs))
+	assertError(t, `errs["/does-not-exist"]`, errs["/does-not-exist"])
+	assertEqual(t, `Destroyed("/dc1", "dc1-vm")`, true, vmLister.Destroyed("/dc1", "dc1-vm"))
+	assertEqual(t, `Destroyed("/dc2", "dc2-vm")`, true, vmLister.Destroyed("/dc2", "dc2-vm"))
+}
+
+func TestJanitorCleanupPathsConcurrency(t *testing.T) {
+	slowVMs := func(prefix string) []*mock.VMData {
+		vms := []*mock.VMData{}
+		for i := 0; i < 4; i++ {
+			vms = append(vms, &mock.VMData{
+				Name:         fmt.Sprintf("%s-%d", prefix, i),
+				Uptime:       2 * time.Hour,
+				BootTime:     timePointer(aTime.Add(-2 * time.Hour)),
+				DestroyDelay: 50 * time.Millisecond,
+			})
+		}
+		return vms
+	}
+	vmLister := mock.NewVMLister(map[string][]*mock.VMData{
+		"/slow-1": slowVMs("slow-1"),
+		"/slow-2": slowVMs("slow-2"),
+		"/fast": {
+			{
+				Name:     "fast-vm",
+				Uptime:   2 * time.Hour,
+				BootTime: timePointer(aTime.Add(-2 * time.Hour)),
+			},
+		},
+	})
+	registry := metrics.NewRegistry()
+
+	janitor := vspherejanitor.NewJanitor(vmLister, &vspherejanitor.JanitorOpts{
+		Cutoff:            time.Hour,
+		Concurrency:       2,
+		RatePerSecond:     10000,
+		RateBurst:         100,
+		SkipNoBootTime:    true,
+		PathConcurrency:   4,
+		GlobalConcurrency: 3,
+		Metrics:           registry,
+	})
+
+	errs := janitor.CleanupPaths(context.TODO(), []string{"/slow-1", "/does-not-exist", "/slow-2", "/fast"}, aTime)
+
+	assertEqual(t, "len(errs)", 1, len(errs))
+	assertError(t, `errs["/does-not-exist"]`, errs["/does-not-exist"])
+	for path, vms := range vmLister.VMData {
+		for _, vm := range vms {
+			assertEqual(t, fmt.Sprintf("Destroyed(%q, %q)", path, vm.Name), true, vmLister.Destroyed(path, vm.Name))
+		}
+	}
+	if vmLister.MaxInFlight() > 3 {
+		t.Errorf("expected at most 3 concurrent destroys across paths, but was %d", vmLister.MaxInFlight())
+	}
+
+	duration := func(path string) metrics.Timer {
+		return registry.Get("vsphere.janitor.cleanup.path." + path + ".duration").(metrics.Timer)
+	}
+	for _, path := range []string{"slow-1", "slow-2", "fast", "does-not-exist"} {
+		assertEqual(t, "count of duration of "+path, int64(1), duration(path).Count())
+	}
+	if duration("fast").Max() >= duration("slow-1").Min() || duration("fast").Max() >= duration("slow-2").Min() {
+		t.Errorf("expected the fast path to finish before the slow ones, but it took %v", time.Duration(duration("fast").Max()))
+	}
+
+	assertEqual(t, "errors of does-not-exist", int64(1), registry.Get("vsphere.janitor.cleanup.path.does-not-exist.errors").(metrics.Meter).Count())
+	for _, path := range []string{"slow-1", "slow-2", "fast"} {
+		assertEqual(t, "errors of "+path, nil, registry.Get("vsphere.janitor.cleanup.path."+path+".errors"))
+	}
+}
+
+func TestJanitorWorkerPool(t *testing.T) {
+	vms := []*mock.VMData{}
+	for i := 0; i < 50; i++ {
+		vms = append(vms, &mock.VMData{
+			Name:         fmt.Sprintf("vm-%d", i),
+			Uptime:       2 * time.Hour,
+			BootTime:     timePointer(aTime.Add(-2 * time.Hour)),
+			DestroyDelay: time.Millisecond,
+		})
+	}
+	vmLister := mock.NewVMLister(map[string][]*mock.VMData{"/": vms})
+
+	janitor := vspherejanitor.NewJanitor(vmLister, &vspherejanitor.JanitorOpts{
+		Cutoff:         time.Hour,
+		Concurrency:    3,
+		RatePerSecond:  10000,
+		RateBurst:      100,
+		SkipNoBootTime: true,
+	})
+
+	err := janitor.Cleanup(context.TODO(), "/", aTime)
+	assertOk(t, "janitor.Cleanup(/)", err)
+
+	for _, vm := range vms {
+		assertEqual(t, fmt.Sprintf("Destroyed(%q, %q)", "/", vm.Name), true, vmLister.Destroyed("/", vm.Name))
+	}
+	if vmLister.MaxInFlight() > 3 {
+		t.Errorf("expected at most 3 concurrent destroys, but was %d", vmLister.MaxInFlight())
+	}
+}
+
+func TestJanitorPowerStates(t *testing.T) {
+	vmLister := mock.NewVMLister(map[string][]*mock.VMData{
+		"/": {
+			{
+				Name:        "recently-suspended",
+				Uptime:      2 * time.Hour,
+				BootTime:    timePointer(aTime.Add(-2 * time.Hour)),
+				PowerState:  vspherejanitor.PowerStateSuspended,
+				SuspendTime: timePointer(aTime.Add(-10 * time.Minute)),
+			},
+			{
+				Name:        "long-suspended",
+				Uptime:      2 * time.Hour,
+				BootTime:    timePointer(aTime.Add(-2 * time.Hour)),
+				PowerState:  vspherejanitor.PowerStateSuspended,
+				SuspendTime: timePointer(aTime.Add(-2 * time.Hour)),
+			},
+			{
+				Name:       "unknown",
+				Uptime:     2 * time.Hour,
+				BootTime:   timePointer(aTime.Add(-2 * time.Hour)),
+				PowerState: vspherejanitor.PowerStateUnknown,
+			},
+		},
+	})
+
+	janitor := vspherejanitor.NewJanitor(vmLister, &vspherejanitor.JanitorOpts{
+		Cutoff:          time.Hour,
+		SuspendedCutoff: time.Hour,
+		Concurrency:     1,
+		RatePerSecond:   100,
+		SkipNoBootTime:  true,
+	})
+
+	err := janitor.Cleanup(context.TODO(), "/", aTime)
+	assertOk(t, "janitor.Cleanup(/)", err)
+
+	assertEqual(t, `Destroyed("/", "recently-suspended")`, false, vmLister.Destroyed("/", "recently-suspended"))
+	assertEqual(t, `PoweredOff("/", "long-suspended")`, true, vmLister.PoweredOff("/", "long-suspended"))
+	assertEqual(t, `Destroyed("/", "long-suspended")`, true, vmLister.Destroyed("/", "long-suspended"))
+	assertEqual(t, `Destroyed("/", "unknown")`, false, vmLister.Destroyed("/", "unknown"))
+}
+
+func TestJanitorSuspendedDefaultCutoff(t *testing.T) {
+	vmLister := mock.NewVMLister(map[string][]*mock.VMData{
+		"/": {
+			{
+				Name:        "recently-suspended",
+				PowerState:  vspherejanitor.PowerStateSuspended,
+				SuspendTime: timePointer(aTime.Add(-10 * time.Minute)),
+			},
+			{
+				Name:        "long-suspended",
+				PowerState:  vspherejanitor.PowerStateSuspended,
+				SuspendTime: timePointer(aTime.Add(-2 * time.Hour)),
+			},
+			{
+				Name:       "suspended-without-time",
+				Uptime:     2 * time.Hour,
+				BootTime:   timePointer(aTime.Add(-2 * time.Hour)),
+				PowerState: vspherejanitor.PowerStateSuspended,
+			},
+		},
+	})
+
+	janitor := vspherejanitor.NewJanitor(vmLister, &vspherejanitor.JanitorOpts{
+		Cutoff:         time.Hour,
+		Concurrency:    1,
+		RatePerSecond:  100,
+		SkipNoBootTime: true,
+	})
+
+	err := janitor.Cleanup(context.TODO(), "/", aTime)
+	assertOk(t, "janitor.Cleanup(/)", err)
+
+	assertEqual(t, `Destroyed("/", "recently-suspended")`, false, vmLister.Destroyed("/", "recently-suspended"))
+	assertEqual(t, `Destroyed("/", "long-suspended")`, true, vmLister.Destroyed("/", "long-suspended"))
+	assertEqual(t, `Destroyed("/", "suspended-without-time")`, true, vmLister.Destroyed("/", "suspended-without-time"))
+}
+
+func TestJanitorCreationCutoff(t *testing.T) {
+	vmLister := mock.NewVMLister(map[string][]*mock.VMData{
+		"/": {
+			{
+				Name:      "old-never-booted",
+				CreatedAt: timePointer(aTime.Add(-3 * time.Hour)),
+			},
+			{
+				Name:      "new-never-booted",
+				CreatedAt: timePointer(aTime.Add(-10 * time.Minute)),
+			},
+			{
+				Name:      "old-rebooted",
+				Uptime:    5 * time.Minute,
+				BootTime:  timePointer(aTime.Add(-5 * time.Minute)),
+				PoweredOn: true,
+				CreatedAt: timePointer(aTime.Add(-3 * time.Hour)),
+			},
+		},
+	})
+
+	janitor := vspherejanitor.NewJanitor(vmLister, &vspherejanitor.JanitorOpts{
+		Cutoff:           time.Hour,
+		ZeroUptimeCutoff: time.Hour,
+		CreationCutoff:   2 * time.Hour,
+		Concurrency:      1,
+		RatePerSecond:    100,
+		SkipNoBootTime:   true,
+	})
+
+	err := janitor.Cleanup(context.TODO(), "/", aTime)
+	assertOk(t, "janitor.Cleanup(/)", err)
+
+	assertEqual(t, `Destroyed("/", "old-never-booted")`, true, vmLister.Destroyed("/", "old-never-booted"))
+	assertEqual(t, `Destroyed("/", "new-never-booted")`, false, vmLister.Destroyed("/", "new-never-booted"))
+	assertEqual(t, `Destroyed("/", "old-rebooted")`, true, vmLister.Destroyed("/", "old-rebooted"))
+}
+
+func TestJanitorConnectionStates(t *testing.T) {
+	for _, unregisterBroken := range []bool{true, false} {
+		flaky := &mock.VMData{
+			Name:            "flaky",
+			Uptime:          10 * time.Minute,
+			BootTime:        timePointer(aTime.Add(-10 * time.Minute)),
+			PoweredOn:       true,
+			ConnectionState: vspherejanitor.ConnectionStateInaccessible,
+		}
+		vmLister := mock.NewVMLister(map[string][]*mock.VMData{
+			"/": {
+				{
+					Name:            "orphaned",
+					Uptime:          2 * time.Hour,
+					BootTime:        timePointer(aTime.Add(-2 * time.Hour)),
+					ConnectionState: vspherejanitor.ConnectionStateOrphaned,
+				},
+				{
+					Name:            "disconnected",
+					Uptime:          2 * time.Hour,
+					BootTime:        timePointer(aTime.Add(-2 * time.Hour)),
+					ConnectionState: vspherejanitor.ConnectionStateDisconnected,
+				},
+				flaky,
+			},
+		})
+
+		janitor := vspherejanitor.NewJanitor(vmLister, &vspherejanitor.JanitorOpts{
+			Cutoff:           time.Hour,
+			Concurrency:      1,
+			RatePerSecond:    100,
+			SkipNoBootTime:   true,
+			UnregisterBroken: unregisterBroken,
+			BrokenCutoff:     time.Hour,
+		})
+
+		// the first cycle only notices that the VMs are broken
+		err := janitor.Cleanup(context.TODO(), "/", aTime)
+		assertOk(t, "janitor.Cleanup(/)", err)
+		assertEqual(t, `Unregistered("/", "orphaned") when first seen broken`, false, vmLister.Unregistered("/", "orphaned"))
+
+		flaky.ConnectionState = vspherejanitor.ConnectionStateConnected
+		err = janitor.Cleanup(context.TODO(), "/", aTime.Add(30*time.Minute))
+		assertOk(t, "janitor.Cleanup(/)", err)
+		assertEqual(t, `Unregistered("/", "orphaned") before broken cutoff`, false, vmLister.Unregistered("/", "orphaned"))
+
+		flaky.ConnectionState = vspherejanitor.ConnectionStateInaccessible
+		err = janitor.Cleanup(context.TODO(), "/", aTime.Add(time.Hour))
+		assertOk(t, "janitor.Cleanup(/)", err)
+
+		assertEqual(t, `Unregistered("/", "orphaned")`, unregisterBroken, vmLister.Unregistered("/", "orphaned"))
+		assertEqual(t, `Destroyed("/", "orphaned")`, false, vmLister.Destroyed("/", "orphaned"))
+		assertEqual(t, `Unregistered("/", "disconnected")`, false, vmLister.Unregistered("/", "disconnected"))
+		assertEqual(t, `Destroyed("/", "disconnected")`, false, vmLister.Destroyed("/", "disconnected"))
+		assertEqual(t, `Unregistered("/", "flaky") after it recovered`, false, vmLister.Unregistered("/", "flaky"))
+		assertEqual(t, `Destroyed("/", "flaky")`, false, vmLister.Destroyed("/", "flaky"))
+	}
+}
+
+func TestJanitorProtectedVMs(t *testing.T) {
+	vmLister := mock.NewVMLister(map[string][]*mock.VMData{
+		"/": {
+			{
+				Name:     "template",
+				Uptime:   2 * time.Hour,
+				BootTime: timePointer(aTime.Add(-2 * time.Hour)),
+				Template: true,
+			},
+			{
+				Name:              "linked-clone-parent",
+				Uptime:            2 * time.Hour,
+				BootTime:          timePointer(aTime.Add(-2 * time.Hour)),
+				LinkedCloneParent: true,
+				ConnectionState:   vspherejanitor.ConnectionStateOrphaned,
+			},
+			{
+				Name:     "linked-clone",
+				Uptime:   2 * time.Hour,
+				BootTime: timePointer(aTime.Add(-2 * time.Hour)),
+			},
+		},
+	})
+
+	janitor := vspherejanitor.NewJanitor(vmLister, &vspherejanitor.JanitorOpts{
+		Cutoff:           time.Hour,
+		Concurrency:      1,
+		RatePerSecond:    100,
+		SkipNoBootTime:   true,
+		UnregisterBroken: true,
+		BrokenCutoff:     time.Hour,
+	})
+
+	err := janitor.Cleanup(context.TODO(), "/", aTime)
+	assertOk(t, "janitor.Cleanup(/)", err)
+
+	assertEqual(t, `Destroyed("/", "template")`, false, vmLister.Destroyed("/", "template"))
+	assertEqual(t, `Destroyed("/", "linked-clone-parent")`, false, vmLister.Destroyed("/", "linked-clone-parent"))
+	assertEqual(t, `Unregistered("/", "linked-clone-parent")`, false, vmLister.Unregistered("/", "linked-clone-parent"))
+	assertEqual(t, `Destroyed("/", "linked-clone")`, true, vmLister.Destroyed("/", "linked-clone"))
+}
+
 func assertEqual(tb testing.TB, name string, expected, actual interface{}) {
 	if expected != actual {
 		tb.Errorf("%s: expected %v, but was %v", name, expected, actual)
@@ -93,3 +701,474 @@ func timePointer(t time.Time) *time.Time {
 	*tp = t
 	return tp
 }
+
+func TestJanitorOptsValidate(t *testing.T) {
+	valid := func() *vspherejanitor.JanitorOpts {
+		return &vspherejanitor.JanitorOpts{
+			Cutoff:        time.Hour,
+			Concurrency:   1,
+			RatePerSecond: 5,
+		}
+	}
+	assertOk(t, "Validate()", valid().Validate())
+
+	for name, invalidate := range map[string]func(*vspherejanitor.JanitorOpts){
+		"zero cutoff":                      func(o *vspherejanitor.JanitorOpts) { o.Cutoff = 0 },
+		"negative zero uptime cutoff":      func(o *vspherejanitor.JanitorOpts) { o.ZeroUptimeCutoff = -time.Minute },
+		"zero concurrency":                 func(o *vspherejanitor.JanitorOpts) { o.Concurrency = 0 },
+		"negative path concurrency":        func(o *vspherejanitor.JanitorOpts) { o.PathConcurrency = -1 },
+		"negative max retries":             func(o *vspherejanitor.JanitorOpts) { o.MaxRetries = -1 },
+		"max backoff below backoff":        func(o *vspherejanitor.JanitorOpts) { o.RetryBackoff, o.MaxRetryBackoff = time.Minute, time.Second },
+		"delete files without unregister":  func(o *vspherejanitor.JanitorOpts) { o.UnregisterDeleteFiles = true },
+		"unregister without broken cutoff": func(o *vspherejanitor.JanitorOpts) { o.UnregisterBroken = true },
+		"negative broken cutoff":           func(o *vspherejanitor.JanitorOpts) { o.BrokenCutoff = -time.Minute },
+		"adaptive throttle factor": func(o *vspherejanitor.JanitorOpts) {
+			o.AdaptiveThrottle = &vspherejanitor.AdaptiveThrottleOpts{DecreaseFactor: 1.5}
+		},
+		"pressure threshold above 1": func(o *vspherejanitor.JanitorOpts) {
+			o.Pressure = &vspherejanitor.PressureOpts{
+				Source:       vspherejanitor.PressureSource{ResourcePool: "/dc/host/cluster/Resources"},
+				CPUThreshold: 90,
+				Cutoff:       time.Minute,
+			}
+		},
+		"unknown cleanup order": func(o *vspherejanitor.JanitorOpts) { o.Order = "random" },
+		"pressure without source": func(o *vspherejanitor.JanitorOpts) {
+			o.Pressure = &vspherejanitor.PressureOpts{CPUThreshold: 0.9, Cutoff: time.Minute}
+		},
+	} {
+		opts := valid()
+		invalidate(opts)
+		assertError(t, "Validate() with "+name, opts.Validate())
+	}
+}
+
+func TestJanitorSetOpts(t *testing.T) {
+	vmLister := mock.NewVMLister(map[string][]*mock.VMData{
+		"/": {
+			{
+				Name:      "booted",
+				Uptime:    2 * time.Hour,
+				BootTime:  timePointer(aTime.Add(-2 * time.Hour)),
+				PoweredOn: true,
+			},
+			{
+				Name: "zero-uptime",
+			},
+		},
+	})
+
+	janitor := vspherejanitor.NewJanitor(vmLister, &vspherejanitor.JanitorOpts{
+		Cutoff:           3 * time.Hour,
+		ZeroUptimeCutoff: time.Hour,
+		Concurrency:      1,
+		RatePerSecond:    100,
+		SkipNoBootTime:   true,
+	})
+
+	err := janitor.Cleanup(context.TODO(), "/", aTime)
+	assertOk(t, "janitor.Cleanup(/)", err)
+	assertEqual(t, `Destroyed("/", "booted")`, false, vmLister.Destroyed("/", "booted"))
+
+	err = janitor.SetOpts(context.TODO(), &vspherejanitor.JanitorOpts{Cutoff: time.Hour})
+	assertError(t, "janitor.SetOpts with invalid options", err)
+
+	err = janitor.SetOpts(context.TODO(), &vspherejanitor.JanitorOpts{
+		Cutoff:           time.Hour,
+		ZeroUptimeCutoff: time.Hour,
+		Concurrency:      1,
+		RatePerSecond:    100,
+		SkipNoBootTime:   true,
+	})
+	assertOk(t, "janitor.SetOpts", err)
+
+	// the zero uptime VM was first seen before the options changed
+	err = janitor.Cleanup(context.TODO(), "/", aTime.Add(2*time.Hour))
+	assertOk(t, "second janitor.Cleanup(/)", err)
+	assertEqual(t, `Destroyed("/", "booted")`, true, vmLister.Destroyed("/", "booted"))
+	assertEqual(t, `Destroyed("/", "zero-uptime")`, true, vmLister.Destroyed("/", "zero-uptime"))
+}
+
+func TestJanitorSetOptsDuringCleanup(t *testing.T) {
+	vmLister := mock.NewVMLister(map[string][]*mock.VMData{
+		"/": {
+			{
+				Name:         "slow",
+				Uptime:       2 * time.Hour,
+				BootTime:     timePointer(aTime.Add(-2 * time.Hour)),
+				DestroyDelay: 500 * time.Millisecond,
+			},
+		},
+	})
+
+	janitor := vspherejanitor.NewJanitor(vmLister, &vspherejanitor.JanitorOpts{
+		Cutoff:         time.Hour,
+		Concurrency:    1,
+		RatePerSecond:  100,
+		SkipNoBootTime: true,
+	})
+
+	done := make(chan error)
+	go func() {
+		done <- janitor.Cleanup(context.TODO(), "/", aTime)
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	err := janitor.SetOpts(context.TODO(), &vspherejanitor.JanitorOpts{
+		Cutoff:         3 * time.Hour,
+		Concurrency:    1,
+		RatePerSecond:  100,
+		SkipNoBootTime: true,
+	})
+	assertOk(t, "janitor.SetOpts", err)
+	assertEqual(t, "janitor.Opts().Cutoff", 3*time.Hour, janitor.Opts().Cutoff)
+
+	select {
+	case <-done:
+		t.Fatal("SetOpts waited for the running cleanup")
+	default:
+	}
+
+	// the running cleanup finishes with the options it started with
+	assertOk(t, "janitor.Cleanup(/)", <-done)
+	assertEqual(t, `Destroyed("/", "slow")`, true, vmLister.Destroyed("/", "slow"))
+}
+
+func TestJanitorSetOptsRestoresThrottledRate(t *testing.T) {
+	vmLister := mock.NewVMLister(map[string][]*mock.VMData{
+		"/": {
+			{
+				Name:            "flaky",
+				Uptime:          2 * time.Hour,
+				BootTime:        timePointer(aTime.Add(-2 * time.Hour)),
+				DestroyErr:      transientError{},
+				DestroyErrCount: 2,
+			},
+		},
+	})
+	registry := metrics.NewRegistry()
+	opts := func(concurrency int) *vspherejanitor.JanitorOpts {
+		return &vspherejanitor.JanitorOpts{
+			Cutoff:         time.Hour,
+			Concurrency:    concurrency,
+			RatePerSecond:  100,
+			RateBurst:      10,
+			SkipNoBootTime: true,
+			MaxRetries:     2,
+			RetryBackoff:   time.Millisecond,
+			AdaptiveThrottle: &vspherejanitor.AdaptiveThrottleOpts{
+				DecreaseFactor: 0.5,
+				IncreaseWindow: 100,
+			},
+			Metrics: registry,
+		}
+	}
+	rate := func() float64 {
+		return registry.Get("vsphere.janitor.throttle.rate").(metrics.GaugeFloat64).Value()
+	}
+
+	janitor := vspherejanitor.NewJanitor(vmLister, opts(2))
+
+	err := janitor.Cleanup(context.TODO(), "/", aTime)
+	assertOk(t, "janitor.Cleanup(/)", err)
+	if rate() >= 100 {
+		t.Fatalf("expected the transient errors to lower the rate, but it was %v", rate())
+	}
+
+	// only the concurrency changes, so the token bucket is kept
+	err = janitor.SetOpts(context.TODO(), opts(4))
+	assertOk(t, "janitor.SetOpts", err)
+	assertEqual(t, "rate after SetOpts", 100.0, rate())
+}
+
+func TestJanitorPauseDuringCleanup(t *testing.T) {
+	vmLister := mock.NewVMLister(map[string][]*mock.VMData{
+		"/": {
+			{
+				Name:         "first",
+				Uptime:       4 * time.Hour,
+				BootTime:     timePointer(aTime.Add(-4 * time.Hour)),
+				DestroyDelay: 300 * time.Millisecond,
+			},
+			{
+				Name:     "second",
+				Uptime:   3 * time.Hour,
+				BootTime: timePointer(aTime.Add(-3 * time.Hour)),
+			},
+			{
+				Name:     "third",
+				Uptime:   2 * time.Hour,
+				BootTime: timePointer(aTime.Add(-2 * time.Hour)),
+			},
+		},
+	})
+
+	janitor := vspherejanitor.NewJanitor(vmLister, &vspherejanitor.JanitorOpts{
+		Cutoff:         time.Hour,
+		Concurrency:    1,
+		RatePerSecond:  100,
+		SkipNoBootTime: true,
+	})
+
+	done := make(chan error)
+	go func() {
+		done <- janitor.Cleanup(context.TODO(), "/", aTime)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	janitor.Pause()
+
+	assertOk(t, "janitor.Cleanup(/)", <-done)
+	assertEqual(t, `Destroyed("/", "first")`, true, vmLister.Destroyed("/", "first"))
+	assertEqual(t, `Destroyed("/", "second")`, false, vmLister.Destroyed("/", "second"))
+	assertEqual(t, `Destroyed("/", "third")`, false, vmLister.Destroyed("/", "third"))
+
+	results := janitor.LastResults()
+	assertEqual(t, "len(janitor.LastResults())", 1, len(results))
+	assertEqual(t, "destroyed", 1, results[0].Destroyed)
+	assertEqual(t, "skipped", 2, results[0].Skipped)
+	assertEqual(t, "failed", 0, results[0].Failed)
+}
+
+func TestJanitorDestroySchedule(t *testing.T) {
+	vmLister := mock.NewVMLister(map[string][]*mock.VMData{
+		"/": {
+			{
+				Name:      "old",
+				Uptime:    2 * time.Hour,
+				BootTime:  timePointer(aTime.Add(-2 * time.Hour)),
+				PoweredOn: true,
+			},
+			{
+				Name: "zero-uptime",
+			},
+		},
+	})
+
+	// aTime is at noon, which is in the blackout
+	schedule, err := vspherejanitor.NewDestroySchedule(nil, []string{"* 9-17 * * *"}, "")
+	assertOk(t, "NewDestroySchedule", err)
+
+	janitor := vspherejanitor.NewJanitor(vmLister, &vspherejanitor.JanitorOpts{
+		Cutoff:           time.Hour,
+		ZeroUptimeCutoff: time.Hour,
+		Concurrency:      1,
+		RatePerSecond:    100,
+		DestroySchedule:  schedule,
+	})
+
+	err = janitor.Cleanup(context.TODO(), "/", aTime)
+	assertOk(t, "janitor.Cleanup(/)", err)
+	err = janitor.Cleanup(context.TODO(), "/", aTime.Add(2*time.Hour))
+	assertOk(t, "second janitor.Cleanup(/)", err)
+	assertEqual(t, `Destroyed("/", "old") during blackout`, false, vmLister.Destroyed("/", "old"))
+	assertEqual(t, `Destroyed("/", "zero-uptime") during blackout`, false, vmLister.Destroyed("/", "zero-uptime"))
+	assertEqual(t, "observe only", true, janitor.LastResults()[0].ObserveOnly)
+
+	// the zero uptime VM is still tracked from the first observing cycle
+	err = janitor.Cleanup(context.TODO(), "/", aTime.Add(8*time.Hour))
+	assertOk(t, "third janitor.Cleanup(/)", err)
+	assertEqual(t, `Destroyed("/", "old") after blackout`, true, vmLister.Destroyed("/", "old"))
+	assertEqual(t, `Destroyed("/", "zero-uptime") after blackout`, true, vmLister.Destroyed("/", "zero-uptime"))
+}
+
+func TestJanitorDestroyScheduleEndsDuringCleanup(t *testing.T) {
+	vmLister := mock.NewVMLister(map[string][]*mock.VMData{
+		"/": {
+			{
+				Name:         "first",
+				Uptime:       4 * time.Hour,
+				BootTime:     timePointer(aTime.Add(-4 * time.Hour)),
+				DestroyDelay: 300 * time.Millisecond,
+			},
+			{
+				Name:     "second",
+				Uptime:   3 * time.Hour,
+				BootTime: timePointer(aTime.Add(-3 * time.Hour)),
+			},
+		},
+	})
+
+	// the blackout starts at aTime, while the first VM is being destroyed
+	schedule, err := vspherejanitor.NewDestroySchedule(nil, []string{"* 12 * * *"}, "")
+	assertOk(t, "NewDestroySchedule", err)
+
+	janitor := vspherejanitor.NewJanitor(vmLister, &vspherejanitor.JanitorOpts{
+		Cutoff:          time.Hour,
+		Concurrency:     1,
+		RatePerSecond:   100,
+		SkipNoBootTime:  true,
+		DestroySchedule: schedule,
+	})
+
+	err = janitor.Cleanup(context.TODO(), "/", aTime.Add(-100*time.Millisecond))
+	assertOk(t, "janitor.Cleanup(/)", err)
+	assertEqual(t, `Destroyed("/", "first")`, true, vmLister.Destroyed("/", "first"))
+	assertEqual(t, `Destroyed("/", "second")`, false, vmLister.Destroyed("/", "second"))
+	assertEqual(t, "skipped", 1, janitor.LastResults()[0].Skipped)
+}
+
+func TestJanitorPressure(t *testing.T) {
+	vmLister := mock.NewVMLister(map[string][]*mock.VMData{
+		"/": {
+			{
+				Name:      "young",
+				Uptime:    30 * time.Minute,
+				BootTime:  timePointer(aTime.Add(-30 * time.Minute)),
+				PoweredOn: true,
+			},
+			{
+				Name:      "new",
+				Uptime:    5 * time.Minute,
+				BootTime:  timePointer(aTime.Add(-5 * time.Minute)),
+				PoweredOn: true,
+			},
+		},
+	})
+	vmLister.Pressure = &vspherejanitor.Pressure{CPU: 0.5, Memory: 0.5, Storage: 0.5}
+
+	janitor := vspherejanitor.NewJanitor(vmLister, &vspherejanitor.JanitorOpts{
+		Cutoff:        time.Hour,
+		Concurrency:   1,
+		RatePerSecond: 100,
+		Pressure: &vspherejanitor.PressureOpts{
+			Source:          vspherejanitor.PressureSource{ResourcePool: "/dc/host/cluster/Resources"},
+			MemoryThreshold: 0.9,
+			Cutoff:          15 * time.Minute,
+		},
+	})
+
+	err := janitor.Cleanup(context.TODO(), "/", aTime)
+	assertOk(t, "janitor.Cleanup(/)", err)
+	assertEqual(t, `Destroyed("/", "young") without pressure`, false, vmLister.Destroyed("/", "young"))
+	assertEqual(t, "pressure mode without pressure", false, janitor.LastResults()[0].PressureMode)
+
+	vmLister.PressureErr = errors.New("vCenter is down")
+	err = janitor.Cleanup(context.TODO(), "/", aTime)
+	assertOk(t, "janitor.Cleanup(/) with pressure error", err)
+	assertEqual(t, `Destroyed("/", "young") with pressure error`, false, vmLister.Destroyed("/", "young"))
+
+	vmLister.PressureErr = nil
+	vmLister.Pressure.Memory = 0.95
+	verdicts, err := janitor.Evaluate(context.TODO(), "/", aTime)
+	assertOk(t, "janitor.Evaluate(/)", err)
+	assertEqual(t, "verdict of young under pressure", vspherejanitor.ActionDestroy, verdicts[0].Action)
+
+	err = janitor.Cleanup(context.TODO(), "/", aTime)
+	assertOk(t, "janitor.Cleanup(/) under pressure", err)
+	assertEqual(t, `Destroyed("/", "young") under pressure`, true, vmLister.Destroyed("/", "young"))
+	assertEqual(t, `Destroyed("/", "new") under pressure`, false, vmLister.Destroyed("/", "new"))
+	assertEqual(t, "pressure mode under pressure", true, janitor.LastResults()[0].PressureMode)
+}
+
+func TestJanitorCleanupOrder(t *testing.T) {
+	vms := func() []*mock.VMData {
+		return []*mock.VMData{
+			{
+				Name:      "first",
+				Uptime:    90 * time.Minute,
+				BootTime:  timePointer(aTime.Add(-90 * time.Minute)),
+				PoweredOn: true,
+				Resources: vspherejanitor.Resources{CPUs: 1, MemoryMB: 1024},
+			},
+			{
+				Name:      "off",
+				Uptime:    6 * time.Hour,
+				BootTime:  timePointer(aTime.Add(-6 * time.Hour)),
+				Resources: vspherejanitor.Resources{CPUs: 2, MemoryMB: 4096},
+			},
+			{
+				Name:      "large",
+				Uptime:    3 * time.Hour,
+				BootTime:  timePointer(aTime.Add(-3 * time.Hour)),
+				PoweredOn: true,
+				Resources: vspherejanitor.Resources{CPUs: 8, MemoryMB: 16384},
+			},
+			{
+				Name:      "old-on",
+				Uptime:    5 * time.Hour,
+				BootTime:  timePointer(aTime.Add(-5 * time.Hour)),
+				PoweredOn: true,
+				Resources: vspherejanitor.Resources{CPUs: 2, MemoryMB: 4096},
+			},
+		}
+	}
+
+	for order, expected := range map[vspherejanitor.CleanupOrder]string{
+		"":                            "off",
+		vspherejanitor.OrderOldest:    "off",
+		vspherejanitor.OrderPoweredOn: "old-on",
+		vspherejanitor.OrderLargest:   "large",
+		vspherejanitor.OrderListed:    "first",
+	} {
+		vmLister := mock.NewVMLister(map[string][]*mock.VMData{"/": vms()})
+		janitor := vspherejanitor.NewJanitor(vmLister, &vspherejanitor.JanitorOpts{
+			Cutoff:              time.Hour,
+			Concurrency:         1,
+			RatePerSecond:       100,
+			MaxDestroysPerCycle: 1,
+			Order:               order,
+		})
+
+		err := janitor.Cleanup(context.TODO(), "/", aTime)
+		assertOk(t, fmt.Sprintf("janitor.Cleanup(/) in order %q", order), err)
+		assertEqual(t, fmt.Sprintf(`Destroyed("/", %q) in order %q`, expected, order), true, vmLister.Destroyed("/", expected))
+	}
+}
+
+func TestJanitorReclaimed(t *testing.T) {
+	vms := func() []*mock.VMData {
+		return []*mock.VMData{
+			{
+				Name:      "on",
+				Uptime:    2 * time.Hour,
+				BootTime:  timePointer(aTime.Add(-2 * time.Hour)),
+				PoweredOn: true,
+				Resources: vspherejanitor.Resources{CPUs: 4, MemoryMB: 8192, StorageCommitted: 10, StorageProvisioned: 40},
+			},
+			{
+				Name:      "off",
+				Uptime:    2 * time.Hour,
+				BootTime:  timePointer(aTime.Add(-2 * time.Hour)),
+				Resources: vspherejanitor.Resources{CPUs: 2, MemoryMB: 2048, StorageCommitted: 5, StorageProvisioned: 20},
+			},
+			{
+				Name:        "suspended",
+				Uptime:      2 * time.Hour,
+				BootTime:    timePointer(aTime.Add(-3 * time.Hour)),
+				PowerState:  vspherejanitor.PowerStateSuspended,
+				SuspendTime: timePointer(aTime.Add(-2 * time.Hour)),
+				Resources:   vspherejanitor.Resources{CPUs: 1, MemoryMB: 1024, StorageCommitted: 1, StorageProvisioned: 1},
+			},
+			{
+				Name:       "stuck",
+				Uptime:     2 * time.Hour,
+				BootTime:   timePointer(aTime.Add(-2 * time.Hour)),
+				DestroyErr: errors.New("nope"),
+				Resources:  vspherejanitor.Resources{CPUs: 16, MemoryMB: 65536, StorageCommitted: 100, StorageProvisioned: 100},
+			},
+		}
+	}
+
+	for _, tc := range []struct {
+		skipDestroy bool
+		expected    vspherejanitor.Resources
+	}{
+		// only the powered on and suspended VMs free CPUs and memory, and
+		// only the powered on one was up for an hour past the cutoff
+		{false, vspherejanitor.Resources{CPUs: 5, MemoryMB: 9216, StorageCommitted: 16, StorageProvisioned: 61, CPUHours: 4}},
+		// powering off frees no storage
+		{true, vspherejanitor.Resources{CPUs: 5, MemoryMB: 9216, CPUHours: 4}},
+	} {
+		vmLister := mock.NewVMLister(map[string][]*mock.VMData{"/": vms()})
+		janitor := vspherejanitor.NewJanitor(vmLister, &vspherejanitor.JanitorOpts{
+			Cutoff:        time.Hour,
+			Concurrency:   2,
+			RatePerSecond: 100,
+			SkipDestroy:   tc.skipDestroy,
+		})
+
+		err := janitor.Cleanup(context.TODO(), "/", aTime)
+		assertOk(t, "janitor.Cleanup(/)", err)
+		assertEqual(t, fmt.Sprintf("reclaimed with skip destroy %v", tc.skipDestroy), tc.expected, janitor.LastResults()[0].Reclaimed)
+	}
+}
@@ -7,7 +7,27 @@ import (
 	"github.com/Sirupsen/logrus"
 )
 
+type fieldsKey struct{}
+
+// ContextWithField returns a context that makes loggers returned from
+// WithContext have the field set.
+func ContextWithField(ctx context.Context, key string, value interface{}) context.Context {
+	fields := logrus.Fields{}
+	if parent, ok := ctx.Value(fieldsKey{}).(logrus.Fields); ok {
+		for k, v := range parent {
+			fields[k] = v
+		}
+	}
+	fields[key] = value
+
+	return context.WithValue(ctx, fieldsKey{}, fields)
+}
+
 // WithContext returns a logger that has global and context fields set on it.
 func WithContext(ctx context.Context) logrus.FieldLogger {
-	return logrus.WithField("pid", os.Getpid())
+	logger := logrus.WithField("pid", os.Getpid())
+	if fields, ok := ctx.Value(fieldsKey{}).(logrus.Fields); ok {
+		logger = logger.WithFields(fields)
+	}
+	return logger
 }
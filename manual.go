@@ -0,0 +1,171 @@
+package vspherejanitor
+
+import (
+	"context"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/honeycombio/libhoney-go"
+	"github.com/pkg/errors"
+	"github.com/rcrowley/go-metrics"
+	"github.com/travis-ci/vsphere-janitor/log"
+)
+
+// A Selection picks VMs to destroy by hand, regardless of the cleanup
+// policy. A VM is selected if its name matches NameRegex or its ID is one of
+// IDs. NameRegex matches anywhere in the name unless it is anchored.
+type Selection struct {
+	NameRegex *regexp.Regexp
+	IDs       []string
+}
+
+func (s *Selection) match(vm VirtualMachine) bool {
+	if s.NameRegex != nil && s.NameRegex.MatchString(vm.Name()) {
+		return true
+	}
+
+	for _, id := range s.IDs {
+		if id != "" && vm.ID() == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Select lists the VMs in path that sel picks. Protected VMs, such as
+// templates, are never selected.
+func (j *Janitor) Select(ctx context.Context, path string, sel *Selection) ([]VirtualMachine, error) {
+	v := j.view()
+
+	ctx = WithRateLimiter(ctx, v.rateLimiter)
+
+	vms, err := v.vmLister.ListVMs(ctx, path)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't list VMs")
+	}
+
+	selected := []VirtualMachine{}
+	for _, vm := range vms {
+		if sel.match(vm) && protection(vm) == "" {
+			selected = append(selected, vm)
+		}
+	}
+
+	return selected, nil
+}
+
+// Destroy powers off and destroys vms in path on request of operator, with
+// the same throttling, retries, logging and metrics as Cleanup. Every VM is
+// recorded in the audit trail with the operator, whether it succeeded or
+// not. Errors are returned per VM, keyed by its name and ID.
+func (j *Janitor) Destroy(ctx context.Context, path string, vms []VirtualMachine, operator string) map[string]error {
+	v := j.view()
+
+	ctx = WithRateLimiter(ctx, v.rateLimiter)
+	cycle := newCleanupCycle(path, 0)
+	cycle.manual = true
+
+	workers := v.opts.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	sem := make(chan struct{}, workers)
+
+	wg := sync.WaitGroup{}
+	errsMutex := sync.Mutex{}
+	errs := make(map[string]error)
+
+	for _, vm := range vms {
+		decision := v.manualDecision(ctx, path, vm, operator)
+
+		if protection := protection(vm); protection != "" {
+			decision.err = errors.Errorf("instance is protected as a %s", protection)
+			v.audit(path, decision)
+			errs[manualKey(vm)] = decision.err
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			decision.err = errors.Wrap(ctx.Err(), "manual destroy was interrupted")
+			v.audit(path, decision)
+			errs[manualKey(vm)] = decision.err
+			continue
+		case sem <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func(decision *cleanupDecision) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			decision.err = v.act(ctx, cycle, decision)
+			v.audit(path, decision)
+
+			if decision.err != nil {
+				errsMutex.Lock()
+				errs[manualKey(decision.vm)] = decision.err
+				errsMutex.Unlock()
+			}
+		}(decision)
+	}
+
+	wg.Wait()
+	v.sendNotifications(ctx, cycle.flush())
+
+	return errs
+}
+
+// manualKey identifies vm in the errors returned by Destroy. The ID alone
+// isn't enough, since broken VMs and VMs being created may not have one.
+func manualKey(vm VirtualMachine) string {
+	if vm.ID() == "" {
+		return vm.Name()
+	}
+	return vm.Name() + " (" + vm.ID() + ")"
+}
+
+func (j *view) manualDecision(ctx context.Context, path string, vm VirtualMachine, operator string) *cleanupDecision {
+	logger := log.WithContext(ctx).
+		WithField("vm", vm.Name()).
+		WithField("vm_id", vm.ID()).
+		WithField("path", path).
+		WithField("operator", operator)
+
+	event := libhoney.NewEvent()
+	event.AddField("meta.type", "manual_destroy")
+	event.AddField("app.vm_id", vm.ID())
+	event.AddField("app.vm_name", vm.Name())
+	event.AddField("app.path", path)
+	event.AddField("app.operator", operator)
+	event.AddField("app.powered_on", vm.PoweredOn())
+	event.AddField("app.power_state", string(vm.PowerState()))
+	event.AddField("app.uptime", vm.Uptime()/time.Second)
+	event.AddField("app.skip_destroy", j.opts.SkipDestroy)
+	addResourceFields(event, vm)
+
+	return &cleanupDecision{vm: vm, action: ActionDestroy, logger: logger, event: event}
+}
+
+// audit records the outcome of a manual destroy. Unlike report, it sends an
+// event for failures too, and it doesn't count them towards giving up on
+// the VM.
+func (j *view) audit(path string, decision *cleanupDecision) {
+	if decision.err != nil {
+		decision.event.AddField("app.err", decision.err.Error())
+		decision.logger.WithError(decision.err).Error("error destroying instance on request")
+		metrics.GetOrRegisterMeter("vsphere.janitor.manual.errors", j.metrics).Mark(1)
+	} else if j.opts.SkipDestroy {
+		decision.logger.Info("powered off instance on request, skipping destroy")
+		metrics.GetOrRegisterMeter("vsphere.janitor.manual.skipped", j.metrics).Mark(1)
+		j.recordReclaimed(path, j.reclaimed(decision.vm, decision.action, j.opts.Cutoff), decision.event)
+	} else {
+		decision.logger.Info("destroyed instance on request")
+		metrics.GetOrRegisterMeter("vsphere.janitor.manual.destroy", j.metrics).Mark(1)
+		j.recordReclaimed(path, j.reclaimed(decision.vm, decision.action, j.opts.Cutoff), decision.event)
+	}
+
+	decision.event.Send()
+}
@@ -0,0 +1,105 @@
+package vspherejanitor_test
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	metrics "github.com/rcrowley/go-metrics"
+	vspherejanitor "github.com/travis-ci/vsphere-janitor"
+	"github.com/travis-ci/vsphere-janitor/mock"
+)
+
+func TestJanitorSelectAndDestroy(t *testing.T) {
+	vmLister := mock.NewVMLister(map[string][]*mock.VMData{
+		"/": {
+			{
+				Name:      "stuck-1",
+				Uptime:    time.Minute,
+				BootTime:  timePointer(aTime.Add(-time.Minute)),
+				PoweredOn: true,
+			},
+			{
+				Name:      "stuck-2",
+				Uptime:    time.Minute,
+				BootTime:  timePointer(aTime.Add(-time.Minute)),
+				PoweredOn: true,
+			},
+			{
+				Name:       "stuck-broken",
+				DestroyErr: errors.New("nope"),
+			},
+			{
+				Name:     "stuck-template",
+				Template: true,
+			},
+			{
+				Name:      "healthy",
+				Uptime:    time.Minute,
+				BootTime:  timePointer(aTime.Add(-time.Minute)),
+				PoweredOn: true,
+			},
+			{
+				Name: "by-id",
+			},
+		},
+	})
+
+	janitor := vspherejanitor.NewJanitor(vmLister, &vspherejanitor.JanitorOpts{
+		Cutoff:        time.Hour,
+		Concurrency:   2,
+		RatePerSecond: 100,
+	})
+
+	vms, err := janitor.Select(context.TODO(), "/", &vspherejanitor.Selection{
+		NameRegex: regexp.MustCompile(`^stuck-`),
+		IDs:       []string{"by-id"},
+	})
+	assertOk(t, "janitor.Select(/)", err)
+	assertEqual(t, "len(vms)", 4, len(vms))
+
+	errs := janitor.Destroy(context.TODO(), "/", vms, "on-call")
+	assertEqual(t, "len(errs)", 1, len(errs))
+	assertError(t, "stuck-broken error", errs["stuck-broken (stuck-broken)"])
+
+	for _, name := range []string{"stuck-1", "stuck-2", "by-id"} {
+		assertEqual(t, `Destroyed("/", "`+name+`")`, true, vmLister.Destroyed("/", name))
+	}
+	assertEqual(t, `PoweredOff("/", "stuck-1")`, true, vmLister.PoweredOff("/", "stuck-1"))
+	assertEqual(t, `Destroyed("/", "stuck-template")`, false, vmLister.Destroyed("/", "stuck-template"))
+	assertEqual(t, `Destroyed("/", "healthy")`, false, vmLister.Destroyed("/", "healthy"))
+}
+
+func TestJanitorDestroySkipDestroy(t *testing.T) {
+	vmLister := mock.NewVMLister(map[string][]*mock.VMData{
+		"/": {
+			{
+				Name:      "stuck",
+				Uptime:    time.Minute,
+				BootTime:  timePointer(aTime.Add(-time.Minute)),
+				PoweredOn: true,
+			},
+		},
+	})
+	registry := metrics.NewRegistry()
+
+	janitor := vspherejanitor.NewJanitor(vmLister, &vspherejanitor.JanitorOpts{
+		Cutoff:        time.Hour,
+		Concurrency:   1,
+		RatePerSecond: 100,
+		SkipDestroy:   true,
+		Metrics:       registry,
+	})
+
+	vms, err := janitor.Select(context.TODO(), "/", &vspherejanitor.Selection{IDs: []string{"stuck"}})
+	assertOk(t, "janitor.Select(/)", err)
+
+	errs := janitor.Destroy(context.TODO(), "/", vms, "on-call")
+	assertEqual(t, "len(errs)", 0, len(errs))
+	assertEqual(t, `PoweredOff("/", "stuck")`, true, vmLister.PoweredOff("/", "stuck"))
+	assertEqual(t, `Destroyed("/", "stuck")`, false, vmLister.Destroyed("/", "stuck"))
+	assertEqual(t, "skipped meter", int64(1), registry.Get("vsphere.janitor.manual.skipped").(metrics.Meter).Count())
+	assertEqual(t, "destroy meter", nil, registry.Get("vsphere.janitor.manual.destroy"))
+}
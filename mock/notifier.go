@@ -0,0 +1,48 @@
+package mock
+
+import (
+	"context"
+	"sync"
+
+	vspherejanitor "github.com/travis-ci/vsphere-janitor"
+)
+
+type Notifier struct {
+	// Block makes Notify wait until it is closed, if it is set.
+	Block chan struct{}
+
+	mutex   sync.Mutex
+	batches [][]vspherejanitor.Notification
+}
+
+func (n *Notifier) Notify(ctx context.Context, notifications []vspherejanitor.Notification) error {
+	if n.Block != nil {
+		<-n.Block
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	n.batches = append(n.batches, notifications)
+	return nil
+}
+
+func (n *Notifier) Batches() [][]vspherejanitor.Notification {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	return n.batches
+}
+
+func (n *Notifier) Kinds() []vspherejanitor.NotificationKind {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	kinds := []vspherejanitor.NotificationKind{}
+	for _, batch := range n.batches {
+		for _, notification := range batch {
+			kinds = append(kinds, notification.Kind)
+		}
+	}
+	return kinds
+}
@@ -12,23 +12,34 @@ import (
 type VMLister struct {
 	VMData map[string][]*VMData
 
-	mutex      sync.Mutex
-	poweredOff map[string][]string
-	destroyed  map[string][]string
+	// Pressure and PressureErr are returned from ReadPressure.
+	Pressure    *vspherejanitor.Pressure
+	PressureErr error
+
+	mutex        sync.Mutex
+	poweredOff   map[string][]string
+	destroyed    map[string][]string
+	unregistered map[string][]string
+
+	inFlight    int
+	maxInFlight int
 }
 
 func NewVMLister(data map[string][]*VMData) *VMLister {
 	poweredOff := make(map[string][]string, len(data))
 	destroyed := make(map[string][]string, len(data))
+	unregistered := make(map[string][]string, len(data))
 	for path := range data {
 		poweredOff[path] = make([]string, 0)
 		destroyed[path] = make([]string, 0)
+		unregistered[path] = make([]string, 0)
 	}
 
 	return &VMLister{
-		VMData:     data,
-		poweredOff: poweredOff,
-		destroyed:  destroyed,
+		VMData:       data,
+		poweredOff:   poweredOff,
+		destroyed:    destroyed,
+		unregistered: unregistered,
 	}
 }
 
@@ -76,7 +87,72 @@ func (vl *VMLister) destroy(path, name string) {
 	vl.destroyed[path] = append(vl.destroyed[path], name)
 }
 
+func (vl *VMLister) Unregistered(path, searchName string) bool {
+	vmNames, ok := vl.unregistered[path]
+	if !ok {
+		return false
+	}
+
+	for _, name := range vmNames {
+		if name == searchName {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (vl *VMLister) unregister(path, name string) {
+	vl.mutex.Lock()
+	defer vl.mutex.Unlock()
+
+	vl.unregistered[path] = append(vl.unregistered[path], name)
+}
+
+// MaxInFlight returns the largest number of Destroy calls that were running
+// at the same time.
+func (vl *VMLister) MaxInFlight() int {
+	vl.mutex.Lock()
+	defer vl.mutex.Unlock()
+
+	return vl.maxInFlight
+}
+
+func (vl *VMLister) startOp() {
+	vl.mutex.Lock()
+	defer vl.mutex.Unlock()
+
+	vl.inFlight++
+	if vl.inFlight > vl.maxInFlight {
+		vl.maxInFlight = vl.inFlight
+	}
+}
+
+func (vl *VMLister) finishOp() {
+	vl.mutex.Lock()
+	defer vl.mutex.Unlock()
+
+	vl.inFlight--
+}
+
+func (vl *VMLister) destroyFails(data *VMData) bool {
+	vl.mutex.Lock()
+	defer vl.mutex.Unlock()
+
+	data.destroyCalls++
+	if data.DestroyErr == nil {
+		return false
+	}
+
+	return data.DestroyErrCount == 0 || data.destroyCalls <= data.DestroyErrCount
+}
+
 func (vl *VMLister) ListVMs(ctx context.Context, path string) ([]vspherejanitor.VirtualMachine, error) {
+	err := vspherejanitor.WaitForRateLimit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	vmData, ok := vl.VMData[path]
 	if !ok {
 		return nil, errors.New("no such path")
@@ -91,11 +167,49 @@ func (vl *VMLister) ListVMs(ctx context.Context, path string) ([]vspherejanitor.
 	return vms, nil
 }
 
+func (vl *VMLister) ReadPressure(ctx context.Context, source vspherejanitor.PressureSource) (*vspherejanitor.Pressure, error) {
+	if vl.PressureErr != nil {
+		return nil, vl.PressureErr
+	}
+	if vl.Pressure == nil {
+		return &vspherejanitor.Pressure{}, nil
+	}
+	return vl.Pressure, nil
+}
+
 type VMData struct {
 	Name      string
 	Uptime    time.Duration
 	BootTime  *time.Time
 	PoweredOn bool
+
+	// PowerState overrides the power state derived from PoweredOn.
+	PowerState  vspherejanitor.PowerState
+	SuspendTime *time.Time
+	CreatedAt   *time.Time
+
+	// ConnectionState defaults to connected.
+	ConnectionState vspherejanitor.ConnectionState
+
+	Template          bool
+	LinkedCloneParent bool
+
+	Resources vspherejanitor.Resources
+
+	// PowerOffErr and DestroyErr, if set, are returned from PowerOff and
+	// Destroy instead of recording the operation.
+	PowerOffErr error
+	DestroyErr  error
+
+	// DestroyErrCount, if set, limits how many times DestroyErr is
+	// returned before Destroy starts succeeding.
+	DestroyErrCount int
+
+	// DestroyDelay makes Destroy block for this long, or until its context
+	// is done.
+	DestroyDelay time.Duration
+
+	destroyCalls int
 }
 
 type VirtualMachine struct {
@@ -121,17 +235,96 @@ func (vm *VirtualMachine) BootTime() *time.Time {
 }
 
 func (vm *VirtualMachine) PoweredOn() bool {
-	return vm.data.PoweredOn
+	return vm.PowerState() == vspherejanitor.PowerStateOn
+}
+
+func (vm *VirtualMachine) PowerState() vspherejanitor.PowerState {
+	if vm.data.PowerState != "" {
+		return vm.data.PowerState
+	}
+
+	if vm.data.PoweredOn {
+		return vspherejanitor.PowerStateOn
+	}
+	return vspherejanitor.PowerStateOff
+}
+
+func (vm *VirtualMachine) SuspendTime() *time.Time {
+	return vm.data.SuspendTime
+}
+
+func (vm *VirtualMachine) CreatedAt() *time.Time {
+	return vm.data.CreatedAt
+}
+
+func (vm *VirtualMachine) ConnectionState() vspherejanitor.ConnectionState {
+	if vm.data.ConnectionState == "" {
+		return vspherejanitor.ConnectionStateConnected
+	}
+
+	return vm.data.ConnectionState
 }
 
-func (vm *VirtualMachine) PowerOff(context.Context) error {
+func (vm *VirtualMachine) Template() bool {
+	return vm.data.Template
+}
+
+func (vm *VirtualMachine) LinkedCloneParent() bool {
+	return vm.data.LinkedCloneParent
+}
+
+func (vm *VirtualMachine) Resources() vspherejanitor.Resources {
+	return vm.data.Resources
+}
+
+func (vm *VirtualMachine) PowerOff(ctx context.Context) error {
+	err := vspherejanitor.WaitForRateLimit(ctx)
+	if err != nil {
+		return err
+	}
+
+	if vm.data.PowerOffErr != nil {
+		return vm.data.PowerOffErr
+	}
+
 	vm.lister.powerOff(vm.path, vm.data.Name)
 
 	return nil
 }
 
-func (vm *VirtualMachine) Destroy(context.Context) error {
+func (vm *VirtualMachine) Destroy(ctx context.Context) error {
+	err := vspherejanitor.WaitForRateLimit(ctx)
+	if err != nil {
+		return err
+	}
+
+	vm.lister.startOp()
+	defer vm.lister.finishOp()
+
+	if vm.data.DestroyDelay > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(vm.data.DestroyDelay):
+		}
+	}
+
+	if vm.lister.destroyFails(vm.data) {
+		return vm.data.DestroyErr
+	}
+
 	vm.lister.destroy(vm.path, vm.data.Name)
 
 	return nil
 }
+
+func (vm *VirtualMachine) Unregister(ctx context.Context, deleteFiles bool) error {
+	err := vspherejanitor.WaitForRateLimit(ctx)
+	if err != nil {
+		return err
+	}
+
+	vm.lister.unregister(vm.path, vm.data.Name)
+
+	return nil
+}
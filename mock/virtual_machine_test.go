@@ -2,9 +2,12 @@ package mock
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"testing"
 	"time"
+
+	vspherejanitor "github.com/travis-ci/vsphere-janitor"
 )
 
 func assertEqual(tb testing.TB, name string, expected, actual interface{}) {
@@ -57,6 +60,23 @@ func TestVMLister(t *testing.T) {
 	}
 }
 
+func TestVirtualMachinePowerState(t *testing.T) {
+	lister := NewVMLister(map[string][]*VMData{
+		"/": []*VMData{
+			{Name: "on", PoweredOn: true},
+			{Name: "off"},
+			{Name: "suspended", PowerState: vspherejanitor.PowerStateSuspended},
+		},
+	})
+
+	vms, err := lister.ListVMs(context.TODO(), "/")
+	assertOk(t, "ListVMs(/)", err)
+	assertEqual(t, "vms[0].PowerState()", vspherejanitor.PowerStateOn, vms[0].PowerState())
+	assertEqual(t, "vms[1].PowerState()", vspherejanitor.PowerStateOff, vms[1].PowerState())
+	assertEqual(t, "vms[2].PowerState()", vspherejanitor.PowerStateSuspended, vms[2].PowerState())
+	assertEqual(t, "vms[2].PoweredOn()", false, vms[2].PoweredOn())
+}
+
 func TestVirtualMachinePowerOff(t *testing.T) {
 	now := time.Now()
 	lister := NewVMLister(map[string][]*VMData{
@@ -116,3 +136,47 @@ func TestVirtualMachineDestroy(t *testing.T) {
 	destroyed = lister.Destroyed("/one", vms[0].Name())
 	assertEqual(t, fmt.Sprintf("lister.Destroyed(%q, %q)", "/one", vms[0].Name()), true, destroyed)
 }
+
+func TestVirtualMachineDestroyErr(t *testing.T) {
+	lister := NewVMLister(map[string][]*VMData{
+		"/one": []*VMData{
+			{
+				Name:            "test-vm",
+				DestroyErr:      errors.New("destroy failed"),
+				DestroyErrCount: 1,
+			},
+		},
+	})
+
+	vms, err := lister.ListVMs(context.TODO(), "/one")
+	assertOk(t, "ListVMs(/one)", err)
+
+	err = vms[0].Destroy(context.TODO())
+	assertError(t, "first vm.Destroy()", err)
+	assertEqual(t, fmt.Sprintf("lister.Destroyed(%q, %q)", "/one", vms[0].Name()), false, lister.Destroyed("/one", vms[0].Name()))
+
+	err = vms[0].Destroy(context.TODO())
+	assertOk(t, "second vm.Destroy()", err)
+	assertEqual(t, fmt.Sprintf("lister.Destroyed(%q, %q)", "/one", vms[0].Name()), true, lister.Destroyed("/one", vms[0].Name()))
+}
+
+func TestVirtualMachineUnregister(t *testing.T) {
+	lister := NewVMLister(map[string][]*VMData{
+		"/one": []*VMData{
+			{
+				Name:            "test-vm",
+				ConnectionState: vspherejanitor.ConnectionStateOrphaned,
+			},
+		},
+	})
+
+	vms, err := lister.ListVMs(context.TODO(), "/one")
+	assertOk(t, "ListVMs(/one)", err)
+	assertEqual(t, "vm.ConnectionState()", vspherejanitor.ConnectionStateOrphaned, vms[0].ConnectionState())
+	assertEqual(t, fmt.Sprintf("lister.Unregistered(%q, %q)", "/one", vms[0].Name()), false, lister.Unregistered("/one", vms[0].Name()))
+
+	err = vms[0].Unregister(context.TODO(), false)
+	assertOk(t, "vm.Unregister()", err)
+	assertEqual(t, fmt.Sprintf("lister.Unregistered(%q, %q)", "/one", vms[0].Name()), true, lister.Unregistered("/one", vms[0].Name()))
+	assertEqual(t, fmt.Sprintf("lister.Destroyed(%q, %q)", "/one", vms[0].Name()), false, lister.Destroyed("/one", vms[0].Name()))
+}
@@ -0,0 +1,232 @@
+package vspherejanitor
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// NotificationKind describes what kind of unusual outcome a Notification is
+// about.
+type NotificationKind string
+
+const (
+	// NotificationPoweredOnDestroyed is sent when a VM that was powered on has
+	// been destroyed.
+	NotificationPoweredOnDestroyed NotificationKind = "powered_on_destroyed"
+
+	// NotificationRepeatedFailure is sent when powering off or destroying a
+	// VM has failed NotifyFailureThreshold times in a row.
+	NotificationRepeatedFailure NotificationKind = "repeated_failure"
+
+	// NotificationSafetyLimit is sent when a cleanup stopped destroying VMs
+	// because it hit MaxDestroysPerCycle.
+	NotificationSafetyLimit NotificationKind = "safety_limit"
+)
+
+// A Notification describes a single unusual outcome of a cleanup.
+type Notification struct {
+	Kind    NotificationKind `json:"kind"`
+	Path    string           `json:"path"`
+	VMName  string           `json:"vm_name,omitempty"`
+	VMID    string           `json:"vm_id,omitempty"`
+	Message string           `json:"message"`
+	Error   string           `json:"error,omitempty"`
+	Time    time.Time        `json:"time"`
+}
+
+// A Notifier is sent the notifications collected during a cycle, once per
+// CleanupPaths or Cleanup call.
+type Notifier interface {
+	Notify(ctx context.Context, notifications []Notification) error
+}
+
+// cleanupCycle holds the state of a single Cleanup call that is shared
+// between the goroutines handling its VMs.
+type cleanupCycle struct {
+	path        string
+	maxDestroys int
+
+	// observeOnly is set when the destroy schedule doesn't allow cleaning
+	// up VMs when the cycle starts.
+	observeOnly bool
+
+	// now is the time the cycle was started for, and started is when it
+	// actually started, to tell the time during the cycle.
+	now, started time.Time
+
+	// manual is set for VMs destroyed on request, which pausing the janitor
+	// doesn't stop.
+	manual bool
+
+	// cutoffs are the cutoffs that apply during the cycle, which are
+	// shorter while capacity is low.
+	cutoffs cutoffs
+
+	mutex         sync.Mutex
+	destroys      int
+	limitTripped  bool
+	notifications []Notification
+}
+
+func newCleanupCycle(path string, maxDestroys int) *cleanupCycle {
+	return &cleanupCycle{
+		path:        path,
+		maxDestroys: maxDestroys,
+	}
+}
+
+// clock returns the current time during the cycle, counted from the time
+// it was started for.
+func (c *cleanupCycle) clock() time.Time {
+	return c.now.Add(time.Since(c.started))
+}
+
+// reserveDestroy returns true if another VM may be destroyed in this cycle.
+// The first time it returns false, a safety limit notification is recorded.
+func (c *cleanupCycle) reserveDestroy(now time.Time) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.maxDestroys <= 0 || c.destroys < c.maxDestroys {
+		c.destroys++
+		return true
+	}
+
+	if !c.limitTripped {
+		c.limitTripped = true
+		c.notifications = append(c.notifications, Notification{
+			Kind:    NotificationSafetyLimit,
+			Path:    c.path,
+			Message: "reached the maximum number of VMs destroyed per cycle",
+			Time:    now,
+		})
+	}
+
+	return false
+}
+
+func (c *cleanupCycle) notify(kind NotificationKind, vm VirtualMachine, message string, err error) {
+	n := Notification{
+		Kind:    kind,
+		Path:    c.path,
+		VMName:  vm.Name(),
+		VMID:    vm.ID(),
+		Message: message,
+		Time:    time.Now().UTC(),
+	}
+	if err != nil {
+		n.Error = err.Error()
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.notifications = append(c.notifications, n)
+}
+
+func (c *cleanupCycle) flush() []Notification {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	notifications := c.notifications
+	c.notifications = nil
+	return notifications
+}
+
+// cycleNotifications collects the notifications of all paths cleaned up in
+// a cycle, so they are sent together once the cycle is done.
+type cycleNotifications struct {
+	mutex         sync.Mutex
+	notifications []Notification
+}
+
+func (n *cycleNotifications) add(notifications []Notification) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.notifications = append(n.notifications, notifications...)
+}
+
+func (n *cycleNotifications) flush() []Notification {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	notifications := n.notifications
+	n.notifications = nil
+	return notifications
+}
+
+// notificationQueueSize is how many batches of notifications can wait to be
+// sent before new ones are dropped.
+const notificationQueueSize = 16
+
+type notificationBatch struct {
+	notifier      Notifier
+	notifications []Notification
+	logger        logrus.FieldLogger
+}
+
+// A notificationQueue sends batches of notifications in the background, so
+// a slow or failing notifier doesn't hold up cleanups or SetOpts.
+type notificationQueue struct {
+	batches chan notificationBatch
+
+	mutex   sync.Mutex
+	pending int
+	idle    chan struct{}
+}
+
+func newNotificationQueue(size int) *notificationQueue {
+	return &notificationQueue{batches: make(chan notificationBatch, size)}
+}
+
+// push queues batch to be sent, or returns false if the queue is full.
+func (q *notificationQueue) push(batch notificationBatch) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	select {
+	case q.batches <- batch:
+	default:
+		return false
+	}
+
+	if q.pending == 0 {
+		q.idle = make(chan struct{})
+	}
+	q.pending++
+	return true
+}
+
+// run sends queued batches with send, one at a time. It never returns.
+func (q *notificationQueue) run(send func(notificationBatch)) {
+	for batch := range q.batches {
+		send(batch)
+
+		q.mutex.Lock()
+		q.pending--
+		if q.pending == 0 {
+			close(q.idle)
+		}
+		q.mutex.Unlock()
+	}
+}
+
+// wait waits until all queued batches have been sent, or ctx is done.
+func (q *notificationQueue) wait(ctx context.Context) error {
+	q.mutex.Lock()
+	if q.pending == 0 {
+		q.mutex.Unlock()
+		return nil
+	}
+	idle := q.idle
+	q.mutex.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
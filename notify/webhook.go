@@ -0,0 +1,158 @@
+// Package notify contains Notifier implementations that deliver janitor
+// notifications to external systems.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	vspherejanitor "github.com/travis-ci/vsphere-janitor"
+	"github.com/travis-ci/vsphere-janitor/log"
+)
+
+// WebhookOpts configures a Webhook.
+type WebhookOpts struct {
+	// URL is where notifications are POSTed to.
+	URL string
+
+	// Headers are added to every request, e.g. for authentication.
+	Headers map[string]string
+
+	// Template, if set, is a text/template used to render the request
+	// body. It is executed with a WebhookPayload, and has a "json" function
+	// available for encoding values. If empty, the payload is sent as JSON.
+	Template string
+
+	// MaxRetries is how many times a failed request is retried.
+	MaxRetries int
+
+	// RetryBackoff is how long to wait before the first retry. The wait is
+	// doubled for every following retry.
+	RetryBackoff time.Duration
+
+	// Timeout bounds every single request.
+	Timeout time.Duration
+}
+
+// WebhookPayload is the data sent to a webhook for a batch of notifications.
+type WebhookPayload struct {
+	Notifications []vspherejanitor.Notification `json:"notifications"`
+}
+
+// Webhook is a Notifier that POSTs batches of notifications to a URL.
+type Webhook struct {
+	opts     WebhookOpts
+	template *template.Template
+	client   *http.Client
+}
+
+// NewWebhook returns a Webhook for the given options, or an error if the
+// options are invalid.
+func NewWebhook(opts *WebhookOpts) (*Webhook, error) {
+	if opts == nil || opts.URL == "" {
+		return nil, errors.New("webhook URL is required")
+	}
+
+	w := &Webhook{
+		opts:   *opts,
+		client: &http.Client{Timeout: opts.Timeout},
+	}
+
+	if opts.Template != "" {
+		tmpl, err := template.New("webhook").Funcs(template.FuncMap{
+			"json": toJSON,
+		}).Parse(opts.Template)
+		if err != nil {
+			return nil, errors.Wrap(err, "couldn't parse webhook template")
+		}
+		w.template = tmpl
+	}
+
+	return w, nil
+}
+
+// Notify sends the notifications as a single request, retrying with backoff
+// on network errors and 429 and 5xx responses.
+func (w *Webhook) Notify(ctx context.Context, notifications []vspherejanitor.Notification) error {
+	body, err := w.render(WebhookPayload{Notifications: notifications})
+	if err != nil {
+		return err
+	}
+
+	backoff := w.opts.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := w.post(ctx, body)
+		if err == nil {
+			return nil
+		}
+
+		if !retry || attempt >= w.opts.MaxRetries {
+			return err
+		}
+
+		log.WithContext(ctx).WithError(err).WithField("backoff", backoff).Warn("webhook request failed, retrying")
+
+		select {
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "gave up retrying webhook request")
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (w *Webhook) render(payload WebhookPayload) ([]byte, error) {
+	if w.template == nil {
+		body, err := json.Marshal(payload)
+		return body, errors.Wrap(err, "couldn't encode webhook payload")
+	}
+
+	var buf bytes.Buffer
+	err := w.template.Execute(&buf, payload)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't render webhook template")
+	}
+
+	return buf.Bytes(), nil
+}
+
+// post sends a single request, and returns whether it is worth retrying if
+// it failed.
+func (w *Webhook) post(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", w.opts.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, errors.Wrap(err, "couldn't create webhook request")
+	}
+	req = req.WithContext(ctx)
+
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range w.opts.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return ctx.Err() == nil, errors.Wrap(err, "error sending webhook request")
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, errors.Errorf("webhook returned status %d", resp.StatusCode)
+}
+
+func toJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
@@ -0,0 +1,151 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	vspherejanitor "github.com/travis-ci/vsphere-janitor"
+)
+
+func assertEqual(tb testing.TB, name string, expected, actual interface{}) {
+	if expected != actual {
+		tb.Errorf("%s: expected %v, but was %v", name, expected, actual)
+	}
+}
+
+func assertOk(tb testing.TB, name string, err error) {
+	if err != nil {
+		tb.Fatalf("%s: returned error: %v", name, err)
+	}
+}
+
+func assertError(tb testing.TB, name string, err error) {
+	if err == nil {
+		tb.Fatalf("%s: didn't return error", name)
+	}
+}
+
+type recordingServer struct {
+	*httptest.Server
+
+	mutex    sync.Mutex
+	failures int
+	requests []*http.Request
+	bodies   []string
+}
+
+func newRecordingServer(failures int, status int) *recordingServer {
+	rs := &recordingServer{failures: failures}
+	rs.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		rs.mutex.Lock()
+		defer rs.mutex.Unlock()
+		rs.requests = append(rs.requests, r)
+		rs.bodies = append(rs.bodies, string(body))
+
+		if rs.failures > 0 {
+			rs.failures--
+			w.WriteHeader(status)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	return rs
+}
+
+var testNotifications = []vspherejanitor.Notification{
+	{
+		Kind:    vspherejanitor.NotificationPoweredOnDestroyed,
+		Path:    "/",
+		VMName:  "test-vm",
+		VMID:    "test-vm-id",
+		Message: "destroyed a VM that was powered on",
+		Time:    time.Date(2016, 01, 15, 12, 0, 0, 0, time.UTC),
+	},
+}
+
+func TestWebhookNotify(t *testing.T) {
+	server := newRecordingServer(0, 0)
+	defer server.Close()
+
+	webhook, err := NewWebhook(&WebhookOpts{
+		URL:     server.URL,
+		Headers: map[string]string{"Authorization": "token secret"},
+	})
+	assertOk(t, "NewWebhook", err)
+
+	err = webhook.Notify(context.TODO(), testNotifications)
+	assertOk(t, "webhook.Notify", err)
+
+	assertEqual(t, "len(requests)", 1, len(server.requests))
+	assertEqual(t, "Authorization header", "token secret", server.requests[0].Header.Get("Authorization"))
+	assertEqual(t, "Content-Type header", "application/json", server.requests[0].Header.Get("Content-Type"))
+
+	var payload WebhookPayload
+	err = json.Unmarshal([]byte(server.bodies[0]), &payload)
+	assertOk(t, "json.Unmarshal", err)
+	assertEqual(t, "len(payload.Notifications)", 1, len(payload.Notifications))
+	assertEqual(t, "payload.Notifications[0].VMName", "test-vm", payload.Notifications[0].VMName)
+}
+
+func TestWebhookTemplate(t *testing.T) {
+	server := newRecordingServer(0, 0)
+	defer server.Close()
+
+	webhook, err := NewWebhook(&WebhookOpts{
+		URL:      server.URL,
+		Template: `{"text": {{ json (index .Notifications 0).VMName }}}`,
+	})
+	assertOk(t, "NewWebhook", err)
+
+	err = webhook.Notify(context.TODO(), testNotifications)
+	assertOk(t, "webhook.Notify", err)
+	assertEqual(t, "body", `{"text": "test-vm"}`, server.bodies[0])
+}
+
+func TestWebhookRetry(t *testing.T) {
+	server := newRecordingServer(2, http.StatusServiceUnavailable)
+	defer server.Close()
+
+	webhook, err := NewWebhook(&WebhookOpts{
+		URL:          server.URL,
+		MaxRetries:   2,
+		RetryBackoff: time.Millisecond,
+	})
+	assertOk(t, "NewWebhook", err)
+
+	err = webhook.Notify(context.TODO(), testNotifications)
+	assertOk(t, "webhook.Notify", err)
+	assertEqual(t, "len(requests)", 3, len(server.requests))
+}
+
+func TestWebhookNoRetryOnClientError(t *testing.T) {
+	server := newRecordingServer(1, http.StatusBadRequest)
+	defer server.Close()
+
+	webhook, err := NewWebhook(&WebhookOpts{
+		URL:          server.URL,
+		MaxRetries:   2,
+		RetryBackoff: time.Millisecond,
+	})
+	assertOk(t, "NewWebhook", err)
+
+	err = webhook.Notify(context.TODO(), testNotifications)
+	assertError(t, "webhook.Notify", err)
+	assertEqual(t, "len(requests)", 1, len(server.requests))
+}
+
+func TestNewWebhookInvalid(t *testing.T) {
+	_, err := NewWebhook(&WebhookOpts{})
+	assertError(t, "NewWebhook(no URL)", err)
+
+	_, err = NewWebhook(&WebhookOpts{URL: "http://example.com", Template: "{{"})
+	assertError(t, "NewWebhook(bad template)", err)
+}
@@ -0,0 +1,90 @@
+package vspherejanitor
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A CleanupOrder is the order in which a cleanup handles the VMs in a path,
+// which matters when rate limits, MaxDestroysPerCycle or a timeout keep it
+// from handling all of them.
+type CleanupOrder string
+
+const (
+	// OrderOldest handles the oldest VMs first. It is the default.
+	OrderOldest CleanupOrder = "oldest"
+
+	// OrderPoweredOn handles powered on VMs first, since they use the most
+	// capacity, and the oldest of them first.
+	OrderPoweredOn CleanupOrder = "powered-on"
+
+	// OrderLargest handles the VMs with the most memory first, then those
+	// with the most CPUs and the most storage, and the oldest of them first.
+	OrderLargest CleanupOrder = "largest"
+
+	// OrderListed handles the VMs in the order vSphere lists them.
+	OrderListed CleanupOrder = "listed"
+)
+
+func (o CleanupOrder) validate() error {
+	switch o {
+	case "", OrderOldest, OrderPoweredOn, OrderLargest, OrderListed:
+		return nil
+	default:
+		return errors.Errorf("unknown cleanup order %q", o)
+	}
+}
+
+// sortVerdicts sorts verdicts in order, keeping the listed order of VMs the
+// order doesn't tell apart.
+func sortVerdicts(verdicts []*Verdict, order CleanupOrder) {
+	var less func(a, b *Verdict) bool
+
+	switch order {
+	case OrderListed:
+		return
+	case OrderPoweredOn:
+		less = func(a, b *Verdict) bool {
+			if a.VM.PoweredOn() != b.VM.PoweredOn() {
+				return a.VM.PoweredOn()
+			}
+			return olderThan(a, b)
+		}
+	case OrderLargest:
+		less = func(a, b *Verdict) bool {
+			ra, rb := a.VM.Resources(), b.VM.Resources()
+			switch {
+			case ra.MemoryMB != rb.MemoryMB:
+				return ra.MemoryMB > rb.MemoryMB
+			case ra.CPUs != rb.CPUs:
+				return ra.CPUs > rb.CPUs
+			case ra.StorageCommitted != rb.StorageCommitted:
+				return ra.StorageCommitted > rb.StorageCommitted
+			default:
+				return olderThan(a, b)
+			}
+		}
+	default:
+		less = olderThan
+	}
+
+	sort.SliceStable(verdicts, func(i, j int) bool { return less(verdicts[i], verdicts[j]) })
+}
+
+func olderThan(a, b *Verdict) bool {
+	return a.age() > b.age()
+}
+
+// age is the longest of the ages the policy looked at, or zero if it didn't
+// look at any.
+func (v *Verdict) age() time.Duration {
+	var age time.Duration
+	for _, a := range v.Ages {
+		if a > age {
+			age = a
+		}
+	}
+	return age
+}
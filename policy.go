@@ -0,0 +1,305 @@
+package vspherejanitor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// An Action is what the janitor does with a VM.
+type Action string
+
+const (
+	ActionSkip       Action = "skip"
+	ActionDestroy    Action = "destroy"
+	ActionUnregister Action = "unregister"
+)
+
+// A Verdict is what the cleanup policy decides to do with a VM, and why.
+type Verdict struct {
+	VM     VirtualMachine
+	Action Action
+
+	// Reason explains the action, e.g. why the VM is skipped.
+	Reason string
+
+	// Ages are the ages of the VM the policy looked at, such as since_boot
+	// or since_creation.
+	Ages map[string]time.Duration
+
+	// ZeroUptimeFirstSeen is when the janitor first saw the VM with zero
+	// uptime and no boot time, if it has.
+	ZeroUptimeFirstSeen *time.Time
+
+	// BrokenFirstSeen is when the janitor first saw the VM with a broken
+	// connection state, if it has.
+	BrokenFirstSeen *time.Time
+
+	// Trace lists the steps the policy took to reach the verdict, for
+	// debugging surprising decisions.
+	Trace []string
+}
+
+func (v *Verdict) tracef(format string, args ...interface{}) {
+	v.Trace = append(v.Trace, fmt.Sprintf(format, args...))
+}
+
+func (v *Verdict) skip(reason string) *Verdict {
+	v.Action = ActionSkip
+	v.Reason = reason
+	v.tracef("skip: %s", reason)
+	return v
+}
+
+// Evaluate lists the VMs in path and returns what the current policy would
+// do with each of them, without doing it. Unlike Cleanup, it doesn't record
+// VMs with zero uptime as seen, and it ignores MaxDestroysPerCycle.
+func (j *Janitor) Evaluate(ctx context.Context, path string, now time.Time) ([]*Verdict, error) {
+	v := j.view()
+
+	ctx = WithRateLimiter(ctx, v.rateLimiter)
+
+	vms, err := v.vmLister.ListVMs(ctx, path)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't list VMs")
+	}
+
+	cutoffs := v.cutoffs(ctx, path)
+	verdicts := make([]*Verdict, 0, len(vms))
+	for _, vm := range vms {
+		verdicts = append(verdicts, v.evaluate(vm, now, cutoffs, false))
+	}
+
+	return verdicts, nil
+}
+
+// Inspect finds the VM in path with the given ID or, failing that, name, and
+// returns what the current policy would do with it, like Evaluate.
+func (j *Janitor) Inspect(ctx context.Context, path, nameOrID string, now time.Time) (*Verdict, error) {
+	v := j.view()
+
+	ctx = WithRateLimiter(ctx, v.rateLimiter)
+
+	vms, err := v.vmLister.ListVMs(ctx, path)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't list VMs")
+	}
+
+	var found VirtualMachine
+	var named []VirtualMachine
+	for _, vm := range vms {
+		if vm.ID() == nameOrID {
+			found = vm
+			break
+		}
+		if vm.Name() == nameOrID {
+			named = append(named, vm)
+		}
+	}
+
+	if found == nil {
+		switch len(named) {
+		case 0:
+			return nil, errors.Errorf("couldn't find VM %s in %s", nameOrID, path)
+		case 1:
+			found = named[0]
+		default:
+			return nil, errors.Errorf("%d VMs in %s are named %s, use the ID instead", len(named), path, nameOrID)
+		}
+	}
+
+	verdict := v.evaluate(found, now, v.cutoffs(ctx, path), false)
+	if verdict.Action != ActionSkip && !v.destroyAllowed(path, now) {
+		verdict.tracef("outside of the destroy schedule of %s, so a cycle would only observe", path)
+	}
+
+	return verdict, nil
+}
+
+// evaluate applies the cleanup policy to vm with the given cutoffs. If
+// record is true, a VM seen with zero uptime for the first time is
+// remembered.
+func (j *view) evaluate(vm VirtualMachine, now time.Time, cutoffs cutoffs, record bool) *Verdict {
+	v := &Verdict{
+		VM:     vm,
+		Action: ActionDestroy,
+		Ages:   make(map[string]time.Duration),
+	}
+
+	if firstSeen, ok := j.getZeroUptimeFirstSeen(vm.ID()); ok {
+		v.ZeroUptimeFirstSeen = &firstSeen
+	}
+
+	if protection := protection(vm); protection != "" {
+		return v.skip("instance is protected as a " + protection)
+	}
+	v.tracef("not a template or linked clone parent")
+
+	if exemption, ok := j.exemption(vm, now); ok {
+		return v.skip("instance is exempted until " + exemption.Until.UTC().Format(time.RFC3339) + ": " + exemption.Reason)
+	}
+
+	connectionState := vm.ConnectionState()
+	v.tracef("connection state is %s", connectionState)
+
+	switch {
+	case connectionState.Broken():
+		if !j.opts.UnregisterBroken {
+			return v.skip("instance has broken connection state " + string(connectionState))
+		}
+		if !j.brokenLongEnough(v, vm, now, record) {
+			return v
+		}
+
+		v.Action = ActionUnregister
+		v.Reason = "instance has had broken connection state " + string(connectionState) + " for more than cutoff"
+		v.tracef("unregister: broken connection state for long enough and unregistering broken VMs is enabled")
+	case connectionState != ConnectionStateConnected:
+		return v.skip("instance isn't connected but " + string(connectionState))
+	default:
+		if cutoffs.pressure != "" {
+			v.tracef("capacity is low, so cutoffs are shortened: %s", cutoffs.pressure)
+		}
+		if !j.stale(v, vm, now, cutoffs, record) {
+			return v
+		}
+	}
+
+	if j.givenUp(vm.ID()) {
+		return v.skip("instance failed too many times, giving up")
+	}
+
+	v.tracef("%s: %s", v.Action, v.Reason)
+	return v
+}
+
+// brokenLongEnough returns true if vm has had a broken connection state for
+// at least the broken cutoff, skipping v otherwise. If record is true, a VM
+// seen broken for the first time is remembered.
+func (j *view) brokenLongEnough(v *Verdict, vm VirtualMachine, now time.Time, record bool) bool {
+	key := brokenKey(vm)
+	if firstSeen, ok := j.getBrokenFirstSeen(key); ok {
+		v.BrokenFirstSeen = &firstSeen
+	}
+
+	v.tracef("first seen broken at %s, broken cutoff is %v", formatTracedTime(v.BrokenFirstSeen), j.opts.BrokenCutoff)
+	if v.BrokenFirstSeen == nil {
+		if record {
+			j.setBrokenFirstSeen(key, now)
+			v.BrokenFirstSeen = &now
+		}
+		v.skip("instance has only just been seen with broken connection state " + string(vm.ConnectionState()))
+		return false
+	}
+
+	v.Ages["since_broken"] = now.Sub(*v.BrokenFirstSeen)
+	if v.Ages["since_broken"] < j.opts.BrokenCutoff {
+		v.skip("instance has had broken connection state " + string(vm.ConnectionState()) + " for less than cutoff")
+		return false
+	}
+
+	return true
+}
+
+// stale returns true if a connected VM is old enough to be cleaned up,
+// setting the reason on v either way.
+func (j *view) stale(v *Verdict, vm VirtualMachine, now time.Time, cutoffs cutoffs, record bool) bool {
+	v.tracef("power state is %s", vm.PowerState())
+
+	switch vm.PowerState() {
+	case PowerStateUnknown:
+		v.skip("instance has unknown power state")
+		return false
+	case PowerStateSuspended:
+		suspendTime := vm.SuspendTime()
+		suspendedCutoff := j.opts.SuspendedCutoff
+		if suspendedCutoff <= 0 {
+			suspendedCutoff = cutoffs.uptime
+		}
+		v.tracef("suspend time is %s, suspended cutoff is %v", formatTracedTime(suspendTime), suspendedCutoff)
+		if suspendTime == nil {
+			v.tracef("suspend time is unknown, applying the usual cutoffs")
+			break
+		}
+
+		v.Ages["since_suspend"] = now.UTC().Sub(*suspendTime)
+		if v.Ages["since_suspend"] < suspendedCutoff {
+			v.skip("instance was suspended recently")
+			return false
+		}
+
+		v.Reason = "instance has been suspended for more than cutoff"
+		return true
+	}
+
+	createdAt := vm.CreatedAt()
+	v.tracef("creation time is %s, creation cutoff is %v", formatTracedTime(createdAt), cutoffs.creation)
+	if cutoffs.creation > 0 && createdAt != nil {
+		v.Ages["since_creation"] = now.UTC().Sub(*createdAt)
+		if v.Ages["since_creation"] < cutoffs.creation {
+			v.skip("instance was created recently")
+			return false
+		}
+
+		v.Reason = "instance was created more than cutoff ago"
+		return true
+	}
+
+	uptime := time.Duration(int(vm.Uptime().Seconds())) * time.Second
+	bootTime := vm.BootTime()
+	v.tracef("uptime is %v, boot time is %s", uptime, formatTracedTime(bootTime))
+
+	if uptime == 0 && bootTime == nil {
+		if vm.ID() == "" {
+			v.skip("VM doesn't have ID yet")
+			return false
+		}
+
+		v.tracef("zero uptime and no boot time, first seen at %s, zero uptime cutoff is %v", formatTracedTime(v.ZeroUptimeFirstSeen), j.opts.ZeroUptimeCutoff)
+		if v.ZeroUptimeFirstSeen == nil {
+			if record {
+				j.setZeroUptimeFirstSeen(vm.ID(), now)
+				v.ZeroUptimeFirstSeen = &now
+			}
+			v.skip("instance has 0 uptime")
+			return false
+		}
+
+		v.Ages["since_first_seen"] = now.Sub(*v.ZeroUptimeFirstSeen)
+		if v.Ages["since_first_seen"] < j.opts.ZeroUptimeCutoff {
+			v.skip("instance has 0 uptime")
+			return false
+		}
+
+		v.Reason = "instance has had 0 uptime for more than timeout"
+		return true
+	}
+
+	if j.opts.SkipNoBootTime && bootTime == nil {
+		v.skip("instance has no boot time")
+		return false
+	}
+
+	if bootTime != nil {
+		v.Ages["since_boot"] = now.UTC().Sub(*bootTime)
+	}
+
+	v.Ages["uptime"] = uptime
+	v.tracef("cutoff is %v, powered on is %v", cutoffs.uptime, vm.PoweredOn())
+	if uptime < cutoffs.uptime && vm.PoweredOn() {
+		v.skip("instance uptime is below cutoff")
+		return false
+	}
+
+	v.Reason = "instance uptime is above cutoff or it is powered off"
+	return true
+}
+
+func formatTracedTime(t *time.Time) string {
+	if t == nil {
+		return "unset"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,102 @@
+package vspherejanitor_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	vspherejanitor "github.com/travis-ci/vsphere-janitor"
+	"github.com/travis-ci/vsphere-janitor/mock"
+)
+
+func TestJanitorEvaluate(t *testing.T) {
+	vmLister := mock.NewVMLister(map[string][]*mock.VMData{
+		"/": {
+			{
+				Name:      "old",
+				Uptime:    3 * time.Hour,
+				BootTime:  timePointer(aTime.Add(-3 * time.Hour)),
+				PoweredOn: true,
+			},
+			{
+				Name:      "new",
+				Uptime:    time.Minute,
+				BootTime:  timePointer(aTime.Add(-time.Minute)),
+				PoweredOn: true,
+			},
+			{
+				Name: "zero-uptime",
+			},
+		},
+	})
+
+	janitor := vspherejanitor.NewJanitor(vmLister, &vspherejanitor.JanitorOpts{
+		Cutoff:           time.Hour,
+		ZeroUptimeCutoff: time.Hour,
+		Concurrency:      1,
+		RatePerSecond:    100,
+		SkipNoBootTime:   true,
+	})
+
+	verdicts, err := janitor.Evaluate(context.TODO(), "/", aTime)
+	assertOk(t, "janitor.Evaluate(/)", err)
+	assertEqual(t, "len(verdicts)", 3, len(verdicts))
+
+	actions := make(map[string]vspherejanitor.Action)
+	for _, verdict := range verdicts {
+		actions[verdict.VM.Name()] = verdict.Action
+	}
+	assertEqual(t, "old action", vspherejanitor.ActionDestroy, actions["old"])
+	assertEqual(t, "new action", vspherejanitor.ActionSkip, actions["new"])
+	assertEqual(t, "zero-uptime action", vspherejanitor.ActionSkip, actions["zero-uptime"])
+	assertEqual(t, `Destroyed("/", "old")`, false, vmLister.Destroyed("/", "old"))
+
+	// evaluating doesn't record the zero uptime VM as seen, so a cleanup
+	// an hour later still only starts counting then
+	verdicts, err = janitor.Evaluate(context.TODO(), "/", aTime.Add(2*time.Hour))
+	assertOk(t, "second janitor.Evaluate(/)", err)
+	for _, verdict := range verdicts {
+		if verdict.VM.Name() == "zero-uptime" {
+			assertEqual(t, "zero-uptime first seen set", true, verdict.ZeroUptimeFirstSeen == nil)
+		}
+	}
+
+	// with what a running janitor remembers, the zero uptime VM is old
+	// enough to be destroyed
+	janitor.RememberZeroUptimeFirstSeen(map[string]time.Time{"zero-uptime": aTime})
+	verdicts, err = janitor.Evaluate(context.TODO(), "/", aTime.Add(2*time.Hour))
+	assertOk(t, "third janitor.Evaluate(/)", err)
+	for _, verdict := range verdicts {
+		if verdict.VM.Name() == "zero-uptime" {
+			assertEqual(t, "zero-uptime action with remembered first seen", vspherejanitor.ActionDestroy, verdict.Action)
+		}
+	}
+}
+
+func TestJanitorInspect(t *testing.T) {
+	vmLister := mock.NewVMLister(map[string][]*mock.VMData{
+		"/": {
+			{
+				Name:      "old",
+				Uptime:    3 * time.Hour,
+				BootTime:  timePointer(aTime.Add(-3 * time.Hour)),
+				PoweredOn: true,
+			},
+		},
+	})
+
+	janitor := vspherejanitor.NewJanitor(vmLister, &vspherejanitor.JanitorOpts{
+		Cutoff:         time.Hour,
+		Concurrency:    1,
+		RatePerSecond:  100,
+		SkipNoBootTime: true,
+	})
+
+	verdict, err := janitor.Inspect(context.TODO(), "/", "old", aTime)
+	assertOk(t, "janitor.Inspect(old)", err)
+	assertEqual(t, "action", vspherejanitor.ActionDestroy, verdict.Action)
+	assertEqual(t, "last trace step", "destroy: instance uptime is above cutoff or it is powered off", verdict.Trace[len(verdict.Trace)-1])
+
+	_, err = janitor.Inspect(context.TODO(), "/", "missing", aTime)
+	assertError(t, "janitor.Inspect(missing)", err)
+}
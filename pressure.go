@@ -0,0 +1,154 @@
+package vspherejanitor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rcrowley/go-metrics"
+	"github.com/travis-ci/vsphere-janitor/log"
+)
+
+// Pressure is how much of the capacity available to VMs is in use, as
+// fractions between 0 and 1.
+type Pressure struct {
+	CPU     float64
+	Memory  float64
+	Storage float64
+}
+
+// A PressureSource is where in the inventory to read capacity pressure from.
+type PressureSource struct {
+	// ResourcePool is the inventory path of the resource pool whose CPU and
+	// memory utilization is read, e.g. the Resources pool of a cluster.
+	ResourcePool string
+
+	// Datastores are the inventory paths of the datastores whose storage
+	// utilization is read. The fullest of them counts.
+	Datastores []string
+}
+
+// A PressureReader is a VMLister that can also read capacity pressure.
+type PressureReader interface {
+	ReadPressure(ctx context.Context, source PressureSource) (*Pressure, error)
+}
+
+// PressureOpts configures cleaning up more aggressively while capacity runs
+// low.
+type PressureOpts struct {
+	Source PressureSource
+
+	// CPUThreshold, MemoryThreshold and StorageThreshold are the
+	// utilizations at or above which capacity counts as low, e.g. 0.9. Zero
+	// ignores that resource.
+	CPUThreshold     float64
+	MemoryThreshold  float64
+	StorageThreshold float64
+
+	// Cutoff replaces Cutoff, and CreationCutoff where it is longer, while
+	// capacity is low.
+	Cutoff time.Duration
+}
+
+func (o *PressureOpts) validate() error {
+	if o.Cutoff <= 0 {
+		return errors.Errorf("pressure cutoff must be positive, but was %v", o.Cutoff)
+	}
+
+	if o.Source.ResourcePool == "" && len(o.Source.Datastores) == 0 {
+		return errors.New("pressure needs a resource pool or datastores to read utilization from")
+	}
+
+	for _, threshold := range []float64{o.CPUThreshold, o.MemoryThreshold, o.StorageThreshold} {
+		if threshold < 0 || threshold > 1 {
+			return errors.Errorf("pressure thresholds must be between 0 and 1, but one was %v", threshold)
+		}
+	}
+
+	if o.CPUThreshold == 0 && o.MemoryThreshold == 0 && o.StorageThreshold == 0 {
+		return errors.New("pressure needs at least one threshold")
+	}
+
+	return nil
+}
+
+// cutoffs are the cutoffs the policy applies to uptime and creation time
+// during a cycle.
+type cutoffs struct {
+	uptime   time.Duration
+	creation time.Duration
+
+	// pressure explains why the cutoffs were shortened, and is empty if
+	// they weren't.
+	pressure string
+}
+
+// cutoffs returns the cutoffs for path, reading capacity pressure if it is
+// configured. If reading it fails, the usual cutoffs apply.
+func (j *view) cutoffs(ctx context.Context, path string) cutoffs {
+	c := cutoffs{uptime: j.opts.Cutoff, creation: j.opts.CreationCutoff}
+
+	o := j.opts.Pressure
+	if o == nil {
+		return c
+	}
+
+	metrics.GetOrRegisterGaugeFloat64("vsphere.janitor.pressure.threshold.cpu", j.metrics).Update(o.CPUThreshold)
+	metrics.GetOrRegisterGaugeFloat64("vsphere.janitor.pressure.threshold.memory", j.metrics).Update(o.MemoryThreshold)
+	metrics.GetOrRegisterGaugeFloat64("vsphere.janitor.pressure.threshold.storage", j.metrics).Update(o.StorageThreshold)
+	metrics.GetOrRegisterGauge("vsphere.janitor.pressure.cutoff_seconds", j.metrics).Update(int64(o.Cutoff / time.Second))
+
+	mode := metrics.GetOrRegisterGauge("vsphere.janitor.cleanup.path."+metricName(path)+".pressure_mode", j.metrics)
+
+	pressure, err := j.readPressure(ctx, o.Source)
+	if err != nil {
+		log.WithContext(ctx).WithError(err).WithField("path", path).Warn("couldn't read capacity pressure, using the usual cutoffs")
+		metrics.GetOrRegisterMeter("vsphere.janitor.pressure.errors", j.metrics).Mark(1)
+		mode.Update(0)
+		return c
+	}
+
+	metrics.GetOrRegisterGaugeFloat64("vsphere.janitor.pressure.cpu", j.metrics).Update(pressure.CPU)
+	metrics.GetOrRegisterGaugeFloat64("vsphere.janitor.pressure.memory", j.metrics).Update(pressure.Memory)
+	metrics.GetOrRegisterGaugeFloat64("vsphere.janitor.pressure.storage", j.metrics).Update(pressure.Storage)
+
+	resources := []struct {
+		name             string
+		value, threshold float64
+	}{
+		{"CPU", pressure.CPU, o.CPUThreshold},
+		{"memory", pressure.Memory, o.MemoryThreshold},
+		{"storage", pressure.Storage, o.StorageThreshold},
+	}
+	for _, r := range resources {
+		if r.threshold > 0 && r.value >= r.threshold {
+			c.pressure = fmt.Sprintf("%s utilization %.0f%% is at or above threshold %.0f%%", r.name, r.value*100, r.threshold*100)
+			break
+		}
+	}
+
+	if c.pressure == "" {
+		mode.Update(0)
+		return c
+	}
+
+	mode.Update(1)
+	if o.Cutoff < c.uptime {
+		c.uptime = o.Cutoff
+	}
+	if c.creation > 0 && o.Cutoff < c.creation {
+		c.creation = o.Cutoff
+	}
+
+	return c
+}
+
+func (j *view) readPressure(ctx context.Context, source PressureSource) (*Pressure, error) {
+	reader, ok := j.vmLister.(PressureReader)
+	if !ok {
+		return nil, errors.Errorf("%T can't read capacity pressure", j.vmLister)
+	}
+
+	return reader.ReadPressure(ctx, source)
+}
@@ -0,0 +1,132 @@
+package vspherejanitor
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// A RateLimiter blocks until another vSphere API call may be made.
+type RateLimiter interface {
+	Wait(ctx context.Context) error
+}
+
+type rateLimiterKey struct{}
+
+// WithRateLimiter returns a context carrying limiter, which VMLister
+// implementations wait on before every vSphere API call.
+func WithRateLimiter(ctx context.Context, limiter RateLimiter) context.Context {
+	return context.WithValue(ctx, rateLimiterKey{}, limiter)
+}
+
+// WaitForRateLimit blocks until the RateLimiter carried by ctx allows
+// another vSphere API call, or ctx is done. It returns immediately if ctx
+// doesn't carry a RateLimiter.
+func WaitForRateLimit(ctx context.Context) error {
+	limiter, ok := ctx.Value(rateLimiterKey{}).(RateLimiter)
+	if !ok || limiter == nil {
+		return nil
+	}
+
+	return limiter.Wait(ctx)
+}
+
+// TokenBucket is a RateLimiter that allows rate calls per second on
+// average, and bursts of up to burst calls.
+type TokenBucket struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  int
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket returns a full TokenBucket. A rate of zero or less means
+// no limit, and a burst of less than one is treated as one.
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &TokenBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Wait takes a token from the bucket, waiting for one to become available
+// if necessary.
+func (tb *TokenBucket) Wait(ctx context.Context) error {
+	wait := tb.reserve()
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		tb.cancel()
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// reserve takes a token, possibly going into debt, and returns how long the
+// caller has to wait until the token is actually available.
+func (tb *TokenBucket) reserve() time.Duration {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+
+	if tb.rate <= 0 {
+		return 0
+	}
+
+	tb.refill(time.Now())
+	tb.tokens--
+	if tb.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-tb.tokens / tb.rate * float64(time.Second))
+}
+
+// cancel returns a token reserved by a Wait that was given up on.
+func (tb *TokenBucket) cancel() {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+
+	tb.tokens++
+}
+
+func (tb *TokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(tb.last)
+	tb.last = now
+
+	tb.tokens += elapsed.Seconds() * tb.rate
+	if tb.tokens > float64(tb.burst) {
+		tb.tokens = float64(tb.burst)
+	}
+}
+
+// SetRate changes the rate of the bucket, keeping the tokens accumulated so
+// far.
+func (tb *TokenBucket) SetRate(rate float64) {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+
+	tb.refill(time.Now())
+	tb.rate = rate
+}
+
+// Rate returns the current rate of the bucket.
+func (tb *TokenBucket) Rate() float64 {
+	tb.mutex.Lock()
+	defer tb.mutex.Unlock()
+
+	return tb.rate
+}
@@ -0,0 +1,94 @@
+package vspherejanitor_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	vspherejanitor "github.com/travis-ci/vsphere-janitor"
+	"github.com/travis-ci/vsphere-janitor/mock"
+)
+
+func TestTokenBucketBurst(t *testing.T) {
+	tb := vspherejanitor.NewTokenBucket(1, 3)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		err := tb.Wait(context.TODO())
+		assertOk(t, "tb.Wait()", err)
+	}
+
+	if time.Since(start) > 100*time.Millisecond {
+		t.Errorf("expected burst to be allowed immediately, but took %v", time.Since(start))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := tb.Wait(ctx)
+	assertError(t, "tb.Wait() after burst", err)
+}
+
+func TestTokenBucketRate(t *testing.T) {
+	tb := vspherejanitor.NewTokenBucket(100, 1)
+
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		err := tb.Wait(context.TODO())
+		assertOk(t, "tb.Wait()", err)
+	}
+
+	if time.Since(start) < 40*time.Millisecond {
+		t.Errorf("expected 6 calls at 100/s to take at least 50ms, but took %v", time.Since(start))
+	}
+}
+
+type countingRateLimiter struct {
+	mutex sync.Mutex
+	calls int
+}
+
+func (l *countingRateLimiter) Wait(ctx context.Context) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.calls++
+	return nil
+}
+
+func TestJanitorRateLimitsAPICalls(t *testing.T) {
+	vmLister := mock.NewVMLister(map[string][]*mock.VMData{
+		"/": {
+			{
+				Name:      "old-powered-on",
+				Uptime:    2 * time.Hour,
+				BootTime:  timePointer(aTime.Add(-2 * time.Hour)),
+				PoweredOn: true,
+			},
+		},
+	})
+	limiter := &countingRateLimiter{}
+
+	janitor := vspherejanitor.NewJanitor(vmLister, &vspherejanitor.JanitorOpts{
+		Cutoff:         time.Hour,
+		Concurrency:    1,
+		RateLimiter:    limiter,
+		SkipNoBootTime: true,
+	})
+
+	err := janitor.Cleanup(context.TODO(), "/", aTime)
+	assertOk(t, "janitor.Cleanup(/)", err)
+
+	// list, power off and destroy
+	assertEqual(t, "limiter.calls", 3, limiter.calls)
+}
+
+func TestJanitorOptsValidateRate(t *testing.T) {
+	err := (&vspherejanitor.JanitorOpts{Cutoff: time.Hour, Concurrency: 1, RatePerSecond: 0}).Validate()
+	assertError(t, "Validate() with zero rate", err)
+
+	err = (&vspherejanitor.JanitorOpts{Cutoff: time.Hour, Concurrency: 1, RatePerSecond: 5, RateBurst: -1}).Validate()
+	assertError(t, "Validate() with negative burst", err)
+
+	err = (&vspherejanitor.JanitorOpts{Cutoff: time.Hour, Concurrency: 1, RatePerSecond: 5, RateBurst: 10}).Validate()
+	assertOk(t, "Validate()", err)
+}
@@ -0,0 +1,97 @@
+package vspherejanitor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/pkg/errors"
+	"github.com/rcrowley/go-metrics"
+)
+
+// IsTransient returns true if the cause of err reports itself as temporary,
+// meaning the operation that failed is worth retrying. VMLister
+// implementations classify their errors this way.
+func IsTransient(err error) bool {
+	t, ok := errors.Cause(err).(interface {
+		Temporary() bool
+	})
+	return ok && t.Temporary()
+}
+
+// TimeoutError is returned when an operation on a VM took longer than its
+// configured timeout.
+type TimeoutError struct {
+	Op       string
+	Duration time.Duration
+	Err      error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s timed out after %v: %v", e.Op, e.Duration, e.Err)
+}
+
+// Timeout always returns true, matching the net.Error convention.
+func (e *TimeoutError) Timeout() bool { return true }
+
+// IsTimeout returns true if the cause of err is an operation timing out.
+func IsTimeout(err error) bool {
+	t, ok := errors.Cause(err).(interface {
+		Timeout() bool
+	})
+	return ok && t.Timeout()
+}
+
+// withTimeout calls op with a context that is cancelled after timeout, and
+// turns the resulting error into a TimeoutError if the deadline was hit. A
+// timeout of zero means no timeout.
+func withTimeout(ctx context.Context, name string, timeout time.Duration, op func(context.Context) error) error {
+	if timeout <= 0 {
+		return op(ctx)
+	}
+
+	opCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err := op(opCtx)
+	if err != nil && opCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		return &TimeoutError{Op: name, Duration: timeout, Err: err}
+	}
+
+	return err
+}
+
+// retry calls op until it succeeds, fails with an error that isn't
+// transient, or has been retried MaxRetries times. Every attempt is bounded
+// by timeout. The wait between attempts starts at RetryBackoff and doubles
+// up to MaxRetryBackoff.
+func (j *view) retry(ctx context.Context, logger logrus.FieldLogger, name string, timeout time.Duration, op func(context.Context) error) error {
+	backoff := j.opts.RetryBackoff
+
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		err := withTimeout(ctx, name, timeout, op)
+		if j.throttle != nil {
+			j.throttle.Observe(time.Since(start), err)
+		}
+
+		if err == nil || !IsTransient(err) || attempt >= j.opts.MaxRetries {
+			return err
+		}
+
+		logger.WithError(err).WithField("attempt", attempt+1).WithField("backoff", backoff).Warn("transient error during " + name + ", retrying")
+		metrics.GetOrRegisterMeter("vsphere.janitor.cleanup.vms.retries", j.metrics).Mark(1)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if j.opts.MaxRetryBackoff > 0 && backoff > j.opts.MaxRetryBackoff {
+			backoff = j.opts.MaxRetryBackoff
+		}
+	}
+}
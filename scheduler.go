@@ -0,0 +1,179 @@
+package vspherejanitor
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rcrowley/go-metrics"
+	"github.com/travis-ci/vsphere-janitor/log"
+)
+
+// SchedulerOpts configures a Scheduler.
+type SchedulerOpts struct {
+	// Interval is the time between the starts of two cycles.
+	Interval time.Duration
+
+	// Jitter is the maximum random delay added before every cycle, so
+	// several janitors don't all hit vSphere at the same time.
+	Jitter time.Duration
+
+	// Timeout bounds how long a single cycle may run before its context is
+	// cancelled. Zero means no timeout.
+	Timeout time.Duration
+
+	// Metrics is the registry cycle metrics are reported to. It defaults to
+	// metrics.DefaultRegistry.
+	Metrics metrics.Registry
+}
+
+// A Scheduler runs cleanup cycles at a fixed interval, never running two
+// cycles at the same time.
+type Scheduler struct {
+	opts SchedulerOpts
+
+	// intervalMutex guards opts.Interval, which SetInterval can change
+	// while Run is running.
+	intervalMutex   sync.Mutex
+	intervalChanged chan struct{}
+
+	triggered chan struct{}
+
+	running int32
+	wg      sync.WaitGroup
+}
+
+// NewScheduler returns a Scheduler with the given options.
+func NewScheduler(opts *SchedulerOpts) *Scheduler {
+	s := &Scheduler{
+		opts:            *opts,
+		intervalChanged: make(chan struct{}, 1),
+		triggered:       make(chan struct{}, 1),
+	}
+	if s.opts.Metrics == nil {
+		s.opts.Metrics = metrics.DefaultRegistry
+	}
+	return s
+}
+
+// SetInterval changes the time between the starts of two cycles. A running
+// Run starts waiting for the new interval right away.
+func (s *Scheduler) SetInterval(interval time.Duration) {
+	s.intervalMutex.Lock()
+	s.opts.Interval = interval
+	s.intervalMutex.Unlock()
+
+	select {
+	case s.intervalChanged <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Scheduler) interval() time.Duration {
+	s.intervalMutex.Lock()
+	defer s.intervalMutex.Unlock()
+	return s.opts.Interval
+}
+
+// Trigger makes a running Run start a cycle right away, unless one is
+// already running. It doesn't change when the following ticks are.
+func (s *Scheduler) Trigger() {
+	select {
+	case s.triggered <- struct{}{}:
+	default:
+	}
+}
+
+// Run starts a cycle immediately and then on every tick of the interval,
+// until ctx is done. A tick that fires while the previous cycle is still
+// running is skipped. Run waits for the running cycle to return before it
+// returns itself.
+func (s *Scheduler) Run(ctx context.Context, cycle func(context.Context)) {
+	interval := s.interval()
+	ticker := time.NewTicker(interval)
+	defer func() { ticker.Stop() }()
+	defer s.wg.Wait()
+
+	metrics.GetOrRegisterGauge("vsphere.janitor.cycle.interval", s.opts.Metrics).Update(int64(interval / time.Millisecond))
+
+	s.tick(ctx, cycle)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.triggered:
+			log.WithContext(ctx).Info("cycle triggered")
+			s.tick(ctx, cycle)
+		case <-s.intervalChanged:
+			interval = s.interval()
+			ticker.Stop()
+			ticker = time.NewTicker(interval)
+			metrics.GetOrRegisterGauge("vsphere.janitor.cycle.interval", s.opts.Metrics).Update(int64(interval / time.Millisecond))
+		case <-ticker.C:
+			if ctx.Err() != nil {
+				return
+			}
+			s.tick(ctx, cycle)
+		}
+	}
+}
+
+// RunOnce runs a single cycle in the foreground, subject to the timeout.
+func (s *Scheduler) RunOnce(ctx context.Context, cycle func(context.Context)) {
+	s.runCycle(ctx, cycle)
+}
+
+func (s *Scheduler) tick(ctx context.Context, cycle func(context.Context)) {
+	if !atomic.CompareAndSwapInt32(&s.running, 0, 1) {
+		log.WithContext(ctx).WithField("interval", s.interval()).Warn("previous cycle still running, skipping")
+		metrics.GetOrRegisterMeter("vsphere.janitor.cycle.skipped", s.opts.Metrics).Mark(1)
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer atomic.StoreInt32(&s.running, 0)
+
+		if s.opts.Jitter > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Duration(rand.Int63n(int64(s.opts.Jitter)))):
+			}
+		}
+
+		s.runCycle(ctx, cycle)
+	}()
+}
+
+func (s *Scheduler) runCycle(ctx context.Context, cycle func(context.Context)) {
+	cycleCtx := ctx
+	if s.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		cycleCtx, cancel = context.WithTimeout(ctx, s.opts.Timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	cycle(cycleCtx)
+	duration := time.Since(start)
+
+	logger := log.WithContext(ctx).WithField("duration", duration)
+	metrics.GetOrRegisterTimer("vsphere.janitor.cycle.duration", s.opts.Metrics).Update(duration)
+
+	if cycleCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		logger.WithField("timeout", s.opts.Timeout).Error("cycle timed out")
+		metrics.GetOrRegisterMeter("vsphere.janitor.cycle.timeout", s.opts.Metrics).Mark(1)
+	}
+
+	if interval := s.interval(); interval > 0 && duration > interval {
+		logger.WithField("interval", interval).Warn("cycle took longer than the interval")
+		metrics.GetOrRegisterMeter("vsphere.janitor.cycle.overrun", s.opts.Metrics).Mark(1)
+	}
+
+	logger.Info("finished cycle")
+}
@@ -0,0 +1,90 @@
+package vspherejanitor_test
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	vspherejanitor "github.com/travis-ci/vsphere-janitor"
+)
+
+func TestSchedulerSkipsOverlappingCycles(t *testing.T) {
+	scheduler := vspherejanitor.NewScheduler(&vspherejanitor.SchedulerOpts{
+		Interval: 10 * time.Millisecond,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	var running, maxRunning, cycles int32
+	scheduler.Run(ctx, func(ctx context.Context) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		if n > atomic.LoadInt32(&maxRunning) {
+			atomic.StoreInt32(&maxRunning, n)
+		}
+		atomic.AddInt32(&cycles, 1)
+
+		<-ctx.Done()
+	})
+
+	assertEqual(t, "max concurrently running cycles", int32(1), atomic.LoadInt32(&maxRunning))
+	assertEqual(t, "cycles", int32(1), atomic.LoadInt32(&cycles))
+	assertEqual(t, "running after Run returned", int32(0), atomic.LoadInt32(&running))
+}
+
+func TestSchedulerTimeout(t *testing.T) {
+	scheduler := vspherejanitor.NewScheduler(&vspherejanitor.SchedulerOpts{
+		Interval: time.Hour,
+		Timeout:  10 * time.Millisecond,
+	})
+
+	var cycleErr error
+	scheduler.RunOnce(context.Background(), func(ctx context.Context) {
+		<-ctx.Done()
+		cycleErr = ctx.Err()
+	})
+
+	assertEqual(t, "cycle context error", context.DeadlineExceeded, cycleErr)
+}
+
+func TestSchedulerRunsAtInterval(t *testing.T) {
+	scheduler := vspherejanitor.NewScheduler(&vspherejanitor.SchedulerOpts{
+		Interval: 10 * time.Millisecond,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 55*time.Millisecond)
+	defer cancel()
+
+	var cycles int32
+	scheduler.Run(ctx, func(ctx context.Context) {
+		atomic.AddInt32(&cycles, 1)
+	})
+
+	if atomic.LoadInt32(&cycles) < 3 {
+		t.Errorf("expected at least 3 cycles, but was %v", atomic.LoadInt32(&cycles))
+	}
+}
+
+func TestSchedulerSetInterval(t *testing.T) {
+	scheduler := vspherejanitor.NewScheduler(&vspherejanitor.SchedulerOpts{
+		Interval: time.Hour,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	var cycles int32
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		scheduler.SetInterval(10 * time.Millisecond)
+	}()
+	scheduler.Run(ctx, func(ctx context.Context) {
+		atomic.AddInt32(&cycles, 1)
+	})
+
+	if atomic.LoadInt32(&cycles) < 3 {
+		t.Errorf("expected at least 3 cycles after shortening the interval, but there were %d", atomic.LoadInt32(&cycles))
+	}
+}
@@ -9,12 +9,78 @@ type VMLister interface {
 	ListVMs(ctx context.Context, path string) ([]VirtualMachine, error)
 }
 
+// PowerState is the power state of a VM.
+type PowerState string
+
+const (
+	PowerStateOn        PowerState = "on"
+	PowerStateOff       PowerState = "off"
+	PowerStateSuspended PowerState = "suspended"
+	PowerStateUnknown   PowerState = "unknown"
+)
+
+// ConnectionState is the state of vCenter's connection to a VM.
+type ConnectionState string
+
+const (
+	ConnectionStateConnected    ConnectionState = "connected"
+	ConnectionStateDisconnected ConnectionState = "disconnected"
+	ConnectionStateOrphaned     ConnectionState = "orphaned"
+	ConnectionStateInaccessible ConnectionState = "inaccessible"
+	ConnectionStateInvalid      ConnectionState = "invalid"
+)
+
+// Broken returns true for the connection states of VMs that can't be
+// powered off or destroyed normally, and have to be unregistered instead.
+func (cs ConnectionState) Broken() bool {
+	return cs == ConnectionStateOrphaned || cs == ConnectionStateInaccessible || cs == ConnectionStateInvalid
+}
+
 type VirtualMachine interface {
 	Name() string
 	ID() string
 	Uptime() time.Duration
 	BootTime() *time.Time
 	PoweredOn() bool
+	PowerState() PowerState
+	// SuspendTime returns when the VM was suspended, or nil if it isn't.
+	SuspendTime() *time.Time
+	// CreatedAt returns when the VM was created, or nil if that's unknown.
+	CreatedAt() *time.Time
+	ConnectionState() ConnectionState
+	// Template returns true if the VM is a template.
+	Template() bool
+	// LinkedCloneParent returns true if other VMs are linked clones whose
+	// disks are backed by this VM's disks.
+	LinkedCloneParent() bool
+	// Resources returns the resources allocated to the VM.
+	Resources() Resources
 	PowerOff(context.Context) error
 	Destroy(context.Context) error
+	// Unregister removes the VM from the inventory without touching its
+	// files, unless deleteFiles is true.
+	Unregister(ctx context.Context, deleteFiles bool) error
+}
+
+// Resources are the resources allocated to a VM. Unknown amounts are zero.
+type Resources struct {
+	CPUs     int `json:"cpus"`
+	MemoryMB int `json:"memory_mb"`
+
+	// StorageCommitted is how many bytes the VM's files take up on
+	// datastores, and StorageProvisioned how many they could take up if its
+	// thin provisioned disks were full.
+	StorageCommitted   int64 `json:"storage_committed_bytes"`
+	StorageProvisioned int64 `json:"storage_provisioned_bytes"`
+
+	// CPUHours is only set on reclaimed resources: the vCPUs of a powered
+	// on VM times how long it was up past the cutoff.
+	CPUHours float64 `json:"cpu_hours"`
+}
+
+// A RawPropertiesVM is a VirtualMachine that can show the raw properties it
+// was built from, keyed by property path, for debugging.
+type RawPropertiesVM interface {
+	VirtualMachine
+	RawProperties() map[string]interface{}
 }
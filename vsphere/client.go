@@ -2,37 +2,95 @@ package vsphere
 
 import (
 	"context"
-	"net/url"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
-	"github.com/travis-ci/jupiter-brain/pkg/vsphereutil"
 	vspherejanitor "github.com/travis-ci/vsphere-janitor"
 	"github.com/travis-ci/vsphere-janitor/log"
 	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/view"
+	"github.com/vmware/govmomi/vim25/methods"
 	"github.com/vmware/govmomi/vim25/mo"
 	"github.com/vmware/govmomi/vim25/types"
 )
 
 type Client struct {
-	clientProvider vsphereutil.ClientProvider
+	sessions *SessionManager
+
+	// cacheMutex guards cache. It is held while looking up what isn't
+	// cached yet, so paths in the same datacenter that are listed at the
+	// same time look it up once.
+	cacheMutex sync.Mutex
+	cache      *cycleCache
+}
+
+// A cycleCache holds what ListVMs looks up once per cycle rather than once
+// per path.
+type cycleCache struct {
+	// datacenters are the datacenters of the folders listed, by path.
+	datacenters map[string]types.ManagedObjectReference
+
+	// parentDirs are the linked clone parent directories in each
+	// datacenter, as returned by parentDirs.
+	parentDirs map[types.ManagedObjectReference]map[string][]string
+}
+
+func newCycleCache() *cycleCache {
+	return &cycleCache{
+		datacenters: make(map[string]types.ManagedObjectReference),
+		parentDirs:  make(map[types.ManagedObjectReference]map[string][]string),
+	}
 }
 
-func NewClient(ctx context.Context, u *url.URL, insecure bool) (*Client, error) {
+func NewClient(ctx context.Context, sessions *SessionManager) (*Client, error) {
 	return &Client{
-		clientProvider: vsphereutil.NewClientProvider(u, insecure),
+		sessions: sessions,
+		cache:    newCycleCache(),
 	}, nil
 }
 
+// StartCycle forgets what ListVMs looked up during the previous cycle, such
+// as which VMs in each datacenter are linked clone parents. Until it is
+// called again, linked clones created since aren't noticed.
+func (c *Client) StartCycle() {
+	c.cacheMutex.Lock()
+	defer c.cacheMutex.Unlock()
+	c.cache = newCycleCache()
+}
+
+// classify classifies err like the package level classify, but first logs
+// in again if the session has expired, making the error worth retrying if
+// that worked.
+func (c *Client) classify(ctx context.Context, err error) error {
+	if err == nil || !isNotAuthenticated(err) {
+		return classify(err)
+	}
+
+	log.WithContext(ctx).WithError(err).Info("vSphere session expired, logging in again")
+	reloginErr := c.sessions.Relogin(ctx)
+	if reloginErr != nil {
+		log.WithContext(ctx).WithError(reloginErr).Error("couldn't log in to vSphere again")
+	}
+
+	return &faultError{err: err, temporary: reloginErr == nil}
+}
+
 func (c *Client) ListVMs(ctx context.Context, path string) ([]vspherejanitor.VirtualMachine, error) {
 	folder, err := c.folder(ctx, path)
 	if err != nil {
 		return nil, errors.Wrap(err, "error finding folder")
 	}
 
+	err = vspherejanitor.WaitForRateLimit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	rawVMs, err := folder.Children(ctx)
 	if err != nil {
-		return nil, errors.Wrap(err, "error listing contents of VM folder")
+		return nil, errors.Wrap(c.classify(ctx, err), "error listing contents of VM folder")
 	}
 
 	vms := make([]vspherejanitor.VirtualMachine, 0, len(rawVMs))
@@ -45,6 +103,11 @@ func (c *Client) ListVMs(ctx context.Context, path string) ([]vspherejanitor.Vir
 
 		mvm := &mo.VirtualMachine{}
 
+		err = vspherejanitor.WaitForRateLimit(ctx)
+		if err != nil {
+			return nil, err
+		}
+
 		err = ovm.Properties(ctx, ovm.Reference(), []string{"config", "summary"}, mvm)
 		if err != nil {
 			log.WithContext(ctx).WithError(err).Info("couldn't get properties for VM")
@@ -52,27 +115,186 @@ func (c *Client) ListVMs(ctx context.Context, path string) ([]vspherejanitor.Vir
 		}
 
 		vm := &VirtualMachine{
-			vm:  ovm,
-			mvm: mvm,
+			client: c,
+			vm:     ovm,
+			mvm:    mvm,
 		}
 
 		vms = append(vms, vm)
 	}
 
+	datacenter, parentDirs, err := c.linkedCloneParents(ctx, path, folder)
+	if err != nil {
+		// a VM that may be a linked clone parent must not be destroyed
+		log.WithContext(ctx).WithError(err).WithField("path", path).Error("couldn't find linked clone parents, protecting all VMs in path")
+		for _, vm := range vms {
+			vm.(*VirtualMachine).linkedCloneParent = true
+		}
+		return vms, nil
+	}
+
+	for _, vm := range vms {
+		vm.(*VirtualMachine).datacenter = datacenter
+	}
+	markLinkedCloneParents(vms, parentDirs)
+
 	return vms, nil
 }
 
+// linkedCloneParents returns the datacenter folder at path is in, and the
+// linked clone parent directories in it, looking each of them up once per
+// cycle.
+func (c *Client) linkedCloneParents(ctx context.Context, path string, folder *object.Folder) (types.ManagedObjectReference, map[string][]string, error) {
+	c.cacheMutex.Lock()
+	defer c.cacheMutex.Unlock()
+
+	datacenter, ok := c.cache.datacenters[path]
+	if !ok {
+		var err error
+		datacenter, err = findDatacenter(ctx, folder.Reference(), c.parent)
+		if err != nil {
+			return datacenter, nil, errors.Wrap(err, "error finding datacenter")
+		}
+		c.cache.datacenters[path] = datacenter
+	}
+
+	parentDirs, ok := c.cache.parentDirs[datacenter]
+	if !ok {
+		var err error
+		parentDirs, err = c.linkedCloneParentDirs(ctx, datacenter)
+		if err != nil {
+			return datacenter, nil, err
+		}
+		c.cache.parentDirs[datacenter] = parentDirs
+	}
+
+	return datacenter, parentDirs, nil
+}
+
+// findDatacenter walks up the inventory from ref, using parent, to the
+// datacenter it is in, which may be nested in folders.
+func findDatacenter(ctx context.Context, ref types.ManagedObjectReference, parent func(context.Context, types.ManagedObjectReference) (*types.ManagedObjectReference, error)) (types.ManagedObjectReference, error) {
+	for ref.Type != "Datacenter" {
+		parentRef, err := parent(ctx, ref)
+		if err != nil {
+			return ref, err
+		}
+		if parentRef == nil {
+			return ref, errors.Errorf("%s %s isn't in a datacenter", ref.Type, ref.Value)
+		}
+		ref = *parentRef
+	}
+
+	return ref, nil
+}
+
+// parent returns the parent of ref in the inventory, or nil if it has none.
+func (c *Client) parent(ctx context.Context, ref types.ManagedObjectReference) (*types.ManagedObjectReference, error) {
+	client, err := c.sessions.Get(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't get govmomi client")
+	}
+
+	err = vspherejanitor.WaitForRateLimit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	entity := mo.ManagedEntity{}
+	err = object.NewCommon(client.Client, ref).Properties(ctx, ref, []string{"parent"}, &entity)
+	if err != nil {
+		return nil, errors.Wrapf(c.classify(ctx, err), "error getting parent of %s %s", ref.Type, ref.Value)
+	}
+
+	return entity.Parent, nil
+}
+
+// linkedCloneParentDirs looks at the disks of all VMs in datacenter, since
+// linked clones are often in other folders than the VMs they were cloned
+// from. It returns the directories of the disks that back their disks,
+// mapped to the configuration files of those VMs.
+func (c *Client) linkedCloneParentDirs(ctx context.Context, datacenter types.ManagedObjectReference) (map[string][]string, error) {
+	client, err := c.sessions.Get(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't get govmomi client")
+	}
+
+	err = vspherejanitor.WaitForRateLimit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := view.NewManager(client.Client).CreateContainerView(ctx, datacenter, []string{"VirtualMachine"}, true)
+	if err != nil {
+		return nil, errors.Wrap(c.classify(ctx, err), "error creating view of VMs in datacenter")
+	}
+	defer v.Destroy(ctx)
+
+	err = vspherejanitor.WaitForRateLimit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var mvms []mo.VirtualMachine
+	err = v.Retrieve(ctx, []string{"VirtualMachine"}, []string{"config.hardware.device", "summary.config.vmPathName"}, &mvms)
+	if err != nil {
+		return nil, errors.Wrap(c.classify(ctx, err), "error getting disks of VMs in datacenter")
+	}
+
+	return parentDirs(mvms), nil
+}
+
+// parentDirs returns the directories of the disks that back disks of mvms,
+// mapped to the configuration files of the VMs whose disks they back.
+func parentDirs(mvms []mo.VirtualMachine) map[string][]string {
+	dirs := make(map[string][]string)
+	for i := range mvms {
+		for _, parentFile := range parentDiskFiles(&mvms[i]) {
+			dir, ok := vmDirectory(parentFile)
+			if ok {
+				dirs[dir] = append(dirs[dir], mvms[i].Summary.Config.VmPathName)
+			}
+		}
+	}
+	return dirs
+}
+
+// markLinkedCloneParents marks the VMs whose directory contains a disk that
+// backs a disk of another VM, according to parentDirs.
+func markLinkedCloneParents(vms []vspherejanitor.VirtualMachine, parentDirs map[string][]string) {
+	for _, vm := range vms {
+		vm := vm.(*VirtualMachine)
+		vmPath := vm.mvm.Summary.Config.VmPathName
+		dir, ok := vmDirectory(vmPath)
+		if !ok {
+			continue
+		}
+
+		for _, child := range parentDirs[dir] {
+			if child != vmPath {
+				vm.linkedCloneParent = true
+				break
+			}
+		}
+	}
+}
+
 func (c *Client) folder(ctx context.Context, path string) (*object.Folder, error) {
-	client, err := c.clientProvider.Get(ctx)
+	client, err := c.sessions.Get(ctx)
 	if err != nil {
 		return nil, errors.Wrap(err, "couldn't get govmomi client")
 	}
 
 	searchIndex := object.NewSearchIndex(client.Client)
 
+	err = vspherejanitor.WaitForRateLimit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	folderRef, err := searchIndex.FindByInventoryPath(ctx, path)
 	if err != nil {
-		return nil, errors.Wrap(err, "error looking for VM folder")
+		return nil, errors.Wrap(c.classify(ctx, err), "error looking for VM folder")
 	}
 
 	if folderRef == nil {
@@ -88,8 +310,15 @@ func (c *Client) folder(ctx context.Context, path string) (*object.Folder, error
 }
 
 type VirtualMachine struct {
-	vm  *object.VirtualMachine
-	mvm *mo.VirtualMachine
+	client *Client
+	vm     *object.VirtualMachine
+	mvm    *mo.VirtualMachine
+
+	// datacenter is the datacenter the VM is in. It is unset if looking it
+	// up failed.
+	datacenter types.ManagedObjectReference
+
+	linkedCloneParent bool
 }
 
 func (vm *VirtualMachine) Name() string {
@@ -120,30 +349,285 @@ func (vm *VirtualMachine) PoweredOn() bool {
 	return vm.mvm.Summary.Runtime.PowerState == types.VirtualMachinePowerStatePoweredOn
 }
 
+func (vm *VirtualMachine) PowerState() vspherejanitor.PowerState {
+	switch vm.mvm.Summary.Runtime.PowerState {
+	case types.VirtualMachinePowerStatePoweredOn:
+		return vspherejanitor.PowerStateOn
+	case types.VirtualMachinePowerStatePoweredOff:
+		return vspherejanitor.PowerStateOff
+	case types.VirtualMachinePowerStateSuspended:
+		return vspherejanitor.PowerStateSuspended
+	default:
+		return vspherejanitor.PowerStateUnknown
+	}
+}
+
+func (vm *VirtualMachine) SuspendTime() *time.Time {
+	return vm.mvm.Summary.Runtime.SuspendTime
+}
+
+// CreatedAt returns config.createDate, which is only set by vSphere 6.7 and
+// later.
+func (vm *VirtualMachine) CreatedAt() *time.Time {
+	if vm.mvm.Config == nil {
+		return nil
+	}
+
+	return vm.mvm.Config.CreateDate
+}
+
+func (vm *VirtualMachine) ConnectionState() vspherejanitor.ConnectionState {
+	switch vm.mvm.Summary.Runtime.ConnectionState {
+	case types.VirtualMachineConnectionStateConnected:
+		return vspherejanitor.ConnectionStateConnected
+	case types.VirtualMachineConnectionStateDisconnected:
+		return vspherejanitor.ConnectionStateDisconnected
+	case types.VirtualMachineConnectionStateOrphaned:
+		return vspherejanitor.ConnectionStateOrphaned
+	case types.VirtualMachineConnectionStateInaccessible:
+		return vspherejanitor.ConnectionStateInaccessible
+	default:
+		return vspherejanitor.ConnectionStateInvalid
+	}
+}
+
+func (vm *VirtualMachine) Template() bool {
+	if vm.mvm.Config == nil {
+		return false
+	}
+
+	return vm.mvm.Config.Template
+}
+
+func (vm *VirtualMachine) LinkedCloneParent() bool {
+	return vm.linkedCloneParent
+}
+
+func (vm *VirtualMachine) Resources() vspherejanitor.Resources {
+	resources := vspherejanitor.Resources{
+		CPUs:     int(vm.mvm.Summary.Config.NumCpu),
+		MemoryMB: int(vm.mvm.Summary.Config.MemorySizeMB),
+	}
+
+	if vm.mvm.Summary.Storage != nil {
+		resources.StorageCommitted = vm.mvm.Summary.Storage.Committed
+		resources.StorageProvisioned = vm.mvm.Summary.Storage.Committed + vm.mvm.Summary.Storage.Uncommitted
+	}
+
+	return resources
+}
+
+// RawProperties returns the properties of the VM the janitor uses, as they
+// were retrieved from vSphere.
+func (vm *VirtualMachine) RawProperties() map[string]interface{} {
+	props := map[string]interface{}{
+		"summary.config.vmPathName":        vm.mvm.Summary.Config.VmPathName,
+		"summary.config.numCpu":            vm.mvm.Summary.Config.NumCpu,
+		"summary.config.memorySizeMB":      vm.mvm.Summary.Config.MemorySizeMB,
+		"summary.storage":                  vm.mvm.Summary.Storage,
+		"summary.quickStats.uptimeSeconds": vm.mvm.Summary.QuickStats.UptimeSeconds,
+		"summary.runtime.bootTime":         vm.mvm.Summary.Runtime.BootTime,
+		"summary.runtime.powerState":       vm.mvm.Summary.Runtime.PowerState,
+		"summary.runtime.suspendTime":      vm.mvm.Summary.Runtime.SuspendTime,
+		"summary.runtime.connectionState":  vm.mvm.Summary.Runtime.ConnectionState,
+		"parentDiskFiles":                  vm.parentDiskFiles(),
+	}
+
+	if vm.mvm.Config != nil {
+		props["config.name"] = vm.mvm.Config.Name
+		props["config.uuid"] = vm.mvm.Config.Uuid
+		props["config.template"] = vm.mvm.Config.Template
+		props["config.createDate"] = vm.mvm.Config.CreateDate
+	}
+
+	return props
+}
+
+// parentDiskFiles returns the files of the disks backing the VM's disks,
+// following the whole chain but not including the disks' own files.
+func (vm *VirtualMachine) parentDiskFiles() []string {
+	return parentDiskFiles(vm.mvm)
+}
+
+// parentDiskFiles returns the files of the disks that back the disks of
+// mvm, which it is a linked clone of.
+func parentDiskFiles(mvm *mo.VirtualMachine) []string {
+	if mvm.Config == nil {
+		return nil
+	}
+
+	var files []string
+	for _, device := range mvm.Config.Hardware.Device {
+		disk, ok := device.(*types.VirtualDisk)
+		if !ok {
+			continue
+		}
+
+		switch backing := disk.Backing.(type) {
+		case *types.VirtualDiskFlatVer2BackingInfo:
+			for parent := backing.Parent; parent != nil; parent = parent.Parent {
+				files = append(files, parent.FileName)
+			}
+		case *types.VirtualDiskSeSparseBackingInfo:
+			for parent := backing.Parent; parent != nil; parent = parent.Parent {
+				files = append(files, parent.FileName)
+			}
+		}
+	}
+
+	return files
+}
+
 func (vm *VirtualMachine) PowerOff(ctx context.Context) error {
+	err := vspherejanitor.WaitForRateLimit(ctx)
+	if err != nil {
+		return err
+	}
+
 	task, err := vm.vm.PowerOff(ctx)
 	if err != nil {
-		return errors.Wrap(err, "couldn't create power off task")
+		return errors.Wrap(vm.client.classify(ctx, err), "couldn't create power off task")
 	}
 
-	err = task.Wait(ctx)
+	err = waitForTask(ctx, task)
 	if err != nil {
-		return errors.Wrap(err, "couldn't power off instance")
+		return errors.Wrap(vm.client.classify(ctx, err), "couldn't power off instance")
 	}
 
 	return nil
 }
 
 func (vm *VirtualMachine) Destroy(ctx context.Context) error {
+	err := vspherejanitor.WaitForRateLimit(ctx)
+	if err != nil {
+		return err
+	}
+
 	task, err := vm.vm.Destroy(ctx)
 	if err != nil {
-		return errors.Wrap(err, "couldn't create destroy task")
+		return errors.Wrap(vm.client.classify(ctx, err), "couldn't create destroy task")
 	}
 
-	err = task.Wait(ctx)
+	err = waitForTask(ctx, task)
 	if err != nil {
-		return errors.Wrap(err, "couldn't destroy instance")
+		return errors.Wrap(vm.client.classify(ctx, err), "couldn't destroy instance")
 	}
 
 	return nil
 }
+
+// Unregister removes the VM from the inventory. If deleteFiles is true, the
+// directory its configuration file is in is deleted afterwards.
+func (vm *VirtualMachine) Unregister(ctx context.Context, deleteFiles bool) error {
+	return unregister(ctx, vm, vm.mvm.Summary.Config.VmPathName, deleteFiles)
+}
+
+// An unregisterer makes the vSphere calls needed to unregister a VM.
+type unregisterer interface {
+	unregister(ctx context.Context) error
+	deleteDirectory(ctx context.Context, dir string) error
+}
+
+// unregister unregisters the VM whose configuration file is at vmPath with
+// u, deleting the directory the file is in if deleteFiles is true and the
+// file is in a directory of its own.
+func unregister(ctx context.Context, u unregisterer, vmPath string, deleteFiles bool) error {
+	// the path has to be captured first, as it's gone from the inventory
+	// after unregistering
+	dir, hasDir := vmDirectory(vmPath)
+
+	err := u.unregister(ctx)
+	if err != nil {
+		return err
+	}
+
+	if !deleteFiles {
+		return nil
+	}
+
+	if !hasDir {
+		log.WithContext(ctx).WithField("vm_path", vmPath).Warn("VM isn't in its own directory, not deleting files")
+		return nil
+	}
+
+	return u.deleteDirectory(ctx, dir)
+}
+
+func (vm *VirtualMachine) unregister(ctx context.Context) error {
+	err := vspherejanitor.WaitForRateLimit(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = vm.vm.Unregister(ctx)
+	if err != nil {
+		return errors.Wrap(vm.client.classify(ctx, err), "couldn't unregister instance")
+	}
+
+	return nil
+}
+
+func (vm *VirtualMachine) deleteDirectory(ctx context.Context, dir string) error {
+	datacenter, err := vm.datacenterObject()
+	if err != nil {
+		return errors.Wrap(err, "couldn't find datacenter to delete files in")
+	}
+
+	err = vspherejanitor.WaitForRateLimit(ctx)
+	if err != nil {
+		return err
+	}
+
+	task, err := object.NewFileManager(vm.vm.Client()).DeleteDatastoreFile(ctx, dir, datacenter)
+	if err != nil {
+		return errors.Wrap(vm.client.classify(ctx, err), "couldn't create delete files task")
+	}
+
+	err = waitForTask(ctx, task)
+	if err != nil {
+		return errors.Wrap(vm.client.classify(ctx, err), "couldn't delete instance files")
+	}
+
+	return nil
+}
+
+func (vm *VirtualMachine) datacenterObject() (*object.Datacenter, error) {
+	if vm.datacenter.Value == "" {
+		return nil, errors.New("datacenter of VM is unknown")
+	}
+
+	return object.NewDatacenter(vm.vm.Client(), vm.datacenter), nil
+}
+
+// vmDirectory returns the datastore path of the directory containing the
+// configuration file at vmPath, such as "[datastore1] vm" for
+// "[datastore1] vm/vm.vmx". It returns false if the file isn't in a
+// directory, so deleting the directory would delete the whole datastore.
+func vmDirectory(vmPath string) (string, bool) {
+	i := strings.LastIndex(vmPath, "/")
+	datastoreEnd := strings.Index(vmPath, "] ")
+	if i < 0 || datastoreEnd < 0 || datastoreEnd > i || strings.Trim(vmPath[datastoreEnd+2:i], "/ ") == "" {
+		return "", false
+	}
+
+	return vmPath[:i], true
+}
+
+// waitForTask waits for the task to finish. If ctx is done first, it asks
+// vSphere to cancel the task so it doesn't keep running in the background.
+func waitForTask(ctx context.Context, task *object.Task) error {
+	err := task.Wait(ctx)
+	if err == nil || ctx.Err() == nil {
+		return err
+	}
+
+	cancelCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	_, cancelErr := methods.CancelTask(cancelCtx, task.Client(), &types.CancelTask{This: task.Reference()})
+	if cancelErr != nil {
+		log.WithContext(ctx).WithError(cancelErr).WithField("task", task.Reference().Value).Warn("couldn't cancel task")
+	}
+
+	return err
+}
@@ -0,0 +1,200 @@
+package vsphere
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	vspherejanitor "github.com/travis-ci/vsphere-janitor"
+	"github.com/vmware/govmomi/vim25/mo"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+func TestVMDirectory(t *testing.T) {
+	testCases := []struct {
+		vmPath string
+		dir    string
+		ok     bool
+	}{
+		{"[datastore1] vm/vm.vmx", "[datastore1] vm", true},
+		{"[datastore1] jobs/vm/vm.vmx", "[datastore1] jobs/vm", true},
+		{"[datastore 2] vm 1/vm 1.vmx", "[datastore 2] vm 1", true},
+		{"[datastore1] vm.vmx", "", false},
+		{"[datastore1] /vm.vmx", "", false},
+		{"vm/vm.vmx", "", false},
+		{"", "", false},
+	}
+
+	for _, tc := range testCases {
+		dir, ok := vmDirectory(tc.vmPath)
+		if dir != tc.dir || ok != tc.ok {
+			t.Errorf("vmDirectory(%q) = %q, %v, expected %q, %v", tc.vmPath, dir, ok, tc.dir, tc.ok)
+		}
+	}
+}
+
+type fakeUnregisterer struct {
+	unregisterErr error
+	calls         []string
+}
+
+func (u *fakeUnregisterer) unregister(ctx context.Context) error {
+	u.calls = append(u.calls, "unregister")
+	return u.unregisterErr
+}
+
+func (u *fakeUnregisterer) deleteDirectory(ctx context.Context, dir string) error {
+	u.calls = append(u.calls, "delete "+dir)
+	return nil
+}
+
+func TestUnregister(t *testing.T) {
+	testCases := []struct {
+		name          string
+		vmPath        string
+		deleteFiles   bool
+		unregisterErr error
+		calls         string
+		err           bool
+	}{
+		{"keeping files", "[datastore1] vm/vm.vmx", false, nil, "[unregister]", false},
+		{"deleting files", "[datastore1] vm/vm.vmx", true, nil, "[unregister delete [datastore1] vm]", false},
+		{"not in own directory", "[datastore1] vm.vmx", true, nil, "[unregister]", false},
+		{"unregister fails", "[datastore1] vm/vm.vmx", true, errors.New("fault"), "[unregister]", true},
+	}
+
+	for _, tc := range testCases {
+		u := &fakeUnregisterer{unregisterErr: tc.unregisterErr}
+		err := unregister(context.TODO(), u, tc.vmPath, tc.deleteFiles)
+
+		if (err != nil) != tc.err {
+			t.Errorf("%s: expected error %v, but was %v", tc.name, tc.err, err)
+		}
+		if fmt.Sprint(u.calls) != tc.calls {
+			t.Errorf("%s: expected calls %s, but were %v", tc.name, tc.calls, u.calls)
+		}
+	}
+}
+
+// linkedClone returns a VM whose configuration file is at vmPath and whose
+// disk is a delta disk backed by the chain of parentFiles.
+func linkedClone(vmPath string, parentFiles ...string) mo.VirtualMachine {
+	var parent *types.VirtualDiskFlatVer2BackingInfo
+	for i := len(parentFiles) - 1; i >= 0; i-- {
+		parent = &types.VirtualDiskFlatVer2BackingInfo{
+			VirtualDeviceFileBackingInfo: types.VirtualDeviceFileBackingInfo{FileName: parentFiles[i]},
+			Parent:                       parent,
+		}
+	}
+
+	mvm := mo.VirtualMachine{Config: &types.VirtualMachineConfigInfo{}}
+	mvm.Summary.Config.VmPathName = vmPath
+	mvm.Config.Hardware.Device = []types.BaseVirtualDevice{
+		&types.VirtualDisk{
+			VirtualDevice: types.VirtualDevice{
+				Backing: &types.VirtualDiskFlatVer2BackingInfo{
+					VirtualDeviceFileBackingInfo: types.VirtualDeviceFileBackingInfo{FileName: vmPath + "-delta.vmdk"},
+					Parent:                       parent,
+				},
+			},
+		},
+	}
+	return mvm
+}
+
+func TestLinkedCloneParents(t *testing.T) {
+	// the clones are in another folder than the VMs they were cloned from,
+	// so the datacenter wide lookup is what finds their parents
+	parentDirs := parentDirs([]mo.VirtualMachine{
+		linkedClone("[datastore1] job-1/job-1.vmx", "[datastore1] base/base-000001.vmdk", "[datastore1] image/image.vmdk"),
+		linkedClone("[datastore1] job-2/job-2.vmx", "[datastore1] base/base-000001.vmdk", "[datastore1] image/image.vmdk"),
+		linkedClone("[datastore1] full/full.vmx"),
+		linkedClone("[datastore1] self/self.vmx", "[datastore1] self/self.vmdk"),
+	})
+
+	expected := map[string]string{
+		"[datastore1] base":  "[[datastore1] job-1/job-1.vmx [datastore1] job-2/job-2.vmx]",
+		"[datastore1] image": "[[datastore1] job-1/job-1.vmx [datastore1] job-2/job-2.vmx]",
+		"[datastore1] self":  "[[datastore1] self/self.vmx]",
+	}
+	if len(parentDirs) != len(expected) {
+		t.Errorf("expected %d parent dirs, but were %v", len(expected), parentDirs)
+	}
+	for dir, children := range expected {
+		if fmt.Sprint(parentDirs[dir]) != children {
+			t.Errorf("expected children of %s to be %s, but were %v", dir, children, parentDirs[dir])
+		}
+	}
+
+	listed := map[string]*VirtualMachine{}
+	vms := []vspherejanitor.VirtualMachine{}
+	for _, vmPath := range []string{"[datastore1] base/base.vmx", "[datastore1] image/image.vmx", "[datastore1] full/full.vmx", "[datastore1] self/self.vmx", "[datastore1] stray.vmx"} {
+		mvm := linkedClone(vmPath)
+		vm := &VirtualMachine{mvm: &mvm}
+		listed[vmPath] = vm
+		vms = append(vms, vm)
+	}
+	markLinkedCloneParents(vms, parentDirs)
+
+	for vmPath, parent := range map[string]bool{
+		"[datastore1] base/base.vmx":   true,
+		"[datastore1] image/image.vmx": true,
+		"[datastore1] full/full.vmx":   false,
+		"[datastore1] self/self.vmx":   false,
+		"[datastore1] stray.vmx":       false,
+	} {
+		if listed[vmPath].LinkedCloneParent() != parent {
+			t.Errorf("expected LinkedCloneParent() of %s to be %v, but was %v", vmPath, parent, listed[vmPath].LinkedCloneParent())
+		}
+	}
+}
+
+func TestFindDatacenter(t *testing.T) {
+	datacenter := types.ManagedObjectReference{Type: "Datacenter", Value: "datacenter-2"}
+	vmFolder := types.ManagedObjectReference{Type: "Folder", Value: "group-v3"}
+	jobsFolder := types.ManagedObjectReference{Type: "Folder", Value: "group-v4"}
+	orphanFolder := types.ManagedObjectReference{Type: "Folder", Value: "group-v5"}
+	nestedFolder := types.ManagedObjectReference{Type: "Folder", Value: "group-d6"}
+	nestedDatacenter := types.ManagedObjectReference{Type: "Datacenter", Value: "datacenter-7"}
+	nestedVMFolder := types.ManagedObjectReference{Type: "Folder", Value: "group-v8"}
+
+	parents := map[types.ManagedObjectReference]*types.ManagedObjectReference{
+		vmFolder:         &datacenter,
+		jobsFolder:       &vmFolder,
+		orphanFolder:     nil,
+		nestedDatacenter: &nestedFolder,
+		nestedVMFolder:   &nestedDatacenter,
+	}
+	parent := func(ctx context.Context, ref types.ManagedObjectReference) (*types.ManagedObjectReference, error) {
+		parent, ok := parents[ref]
+		if !ok {
+			return nil, fmt.Errorf("no such object %s", ref.Value)
+		}
+		return parent, nil
+	}
+
+	testCases := []struct {
+		ref        types.ManagedObjectReference
+		datacenter types.ManagedObjectReference
+		ok         bool
+	}{
+		{vmFolder, datacenter, true},
+		{jobsFolder, datacenter, true},
+		{datacenter, datacenter, true},
+		{nestedVMFolder, nestedDatacenter, true},
+		{orphanFolder, types.ManagedObjectReference{}, false},
+		{types.ManagedObjectReference{Type: "Folder", Value: "group-v9"}, types.ManagedObjectReference{}, false},
+	}
+
+	for _, tc := range testCases {
+		dc, err := findDatacenter(context.Background(), tc.ref, parent)
+		if (err == nil) != tc.ok {
+			t.Errorf("findDatacenter(%s): expected ok=%v, got error %v", tc.ref.Value, tc.ok, err)
+			continue
+		}
+		if tc.ok && dc != tc.datacenter {
+			t.Errorf("findDatacenter(%s): expected %s, got %s", tc.ref.Value, tc.datacenter.Value, dc.Value)
+		}
+	}
+}
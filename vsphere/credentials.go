@@ -0,0 +1,50 @@
+package vsphere
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// A PasswordSource returns the password to log in to vSphere with.
+type PasswordSource func(ctx context.Context) (string, error)
+
+// StaticPassword returns a PasswordSource that always returns password.
+func StaticPassword(password string) PasswordSource {
+	return func(ctx context.Context) (string, error) {
+		return password, nil
+	}
+}
+
+// PasswordFromFile returns a PasswordSource that reads the file at path,
+// such as a mounted secret, ignoring trailing newlines.
+func PasswordFromFile(path string) PasswordSource {
+	return func(ctx context.Context) (string, error) {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", errors.Wrap(err, "couldn't read password file")
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	}
+}
+
+// PasswordFromCommand returns a PasswordSource that runs command with sh -c
+// and uses its output, ignoring trailing newlines, so the password can come
+// from a secret manager.
+func PasswordFromCommand(command string) PasswordSource {
+	return func(ctx context.Context) (string, error) {
+		stderr := &bytes.Buffer{}
+		cmd := exec.CommandContext(ctx, "sh", "-c", command)
+		cmd.Stderr = stderr
+
+		out, err := cmd.Output()
+		if err != nil {
+			return "", errors.Wrapf(err, "password command failed: %s", strings.TrimSpace(stderr.String()))
+		}
+		return strings.TrimRight(string(out), "\r\n"), nil
+	}
+}
@@ -0,0 +1,51 @@
+package vsphere
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPasswordFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vsphere-janitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "password")
+	err = ioutil.WriteFile(path, []byte("s3cr3t\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	password, err := PasswordFromFile(path)(context.TODO())
+	if err != nil {
+		t.Fatalf("PasswordFromFile returned error: %v", err)
+	}
+	if password != "s3cr3t" {
+		t.Errorf("expected password %q, but was %q", "s3cr3t", password)
+	}
+
+	_, err = PasswordFromFile(filepath.Join(dir, "missing"))(context.TODO())
+	if err == nil {
+		t.Error("PasswordFromFile didn't return error for missing file")
+	}
+}
+
+func TestPasswordFromCommand(t *testing.T) {
+	password, err := PasswordFromCommand("echo s3cr3t")(context.TODO())
+	if err != nil {
+		t.Fatalf("PasswordFromCommand returned error: %v", err)
+	}
+	if password != "s3cr3t" {
+		t.Errorf("expected password %q, but was %q", "s3cr3t", password)
+	}
+
+	_, err = PasswordFromCommand("echo oops >&2; exit 1")(context.TODO())
+	if err == nil {
+		t.Error("PasswordFromCommand didn't return error for failing command")
+	}
+}
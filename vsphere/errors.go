@@ -0,0 +1,145 @@
+package vsphere
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/vmware/govmomi/task"
+	"github.com/vmware/govmomi/vim25/soap"
+	"github.com/vmware/govmomi/vim25/types"
+)
+
+// faultError wraps errors returned from vSphere calls and tasks, and
+// reports through Temporary whether the fault is worth retrying. It
+// deliberately has no Cause method, so errors.Cause stops at it.
+type faultError struct {
+	err       error
+	temporary bool
+}
+
+func (e *faultError) Error() string   { return e.err.Error() }
+func (e *faultError) Temporary() bool { return e.temporary }
+
+// classify wraps err in a faultError, so callers can tell transient faults
+// such as TaskInProgress, InvalidState and network errors from permanent
+// ones.
+func classify(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &faultError{err: err, temporary: isTransient(err)}
+}
+
+func isTransient(err error) bool {
+	cause := errors.Cause(err)
+	if cause == context.Canceled || cause == context.DeadlineExceeded {
+		return false
+	}
+
+	// a certificate that can't be verified stays that way
+	if isTLSError(cause) {
+		return false
+	}
+
+	switch e := cause.(type) {
+	case *url.Error:
+		switch e.Err {
+		case context.Canceled, context.DeadlineExceeded:
+			return false
+		case io.EOF, io.ErrUnexpectedEOF:
+			return true
+		}
+		_, ok := e.Err.(net.Error)
+		return ok
+	case net.Error:
+		return true
+	}
+
+	switch vimFault(cause).(type) {
+	case types.TaskInProgress, *types.TaskInProgress, types.BaseTaskInProgress,
+		types.InvalidState, *types.InvalidState, types.BaseInvalidState,
+		types.InvalidPowerState, *types.InvalidPowerState:
+		return true
+	}
+
+	return false
+}
+
+// IsCertificateError returns true if err is about verifying the vCenter
+// certificate or a failed TLS handshake, which retrying won't fix.
+func IsCertificateError(err error) bool {
+	cause := errors.Cause(err)
+	if fe, ok := cause.(*faultError); ok {
+		cause = errors.Cause(fe.err)
+	}
+
+	return isTLSError(cause)
+}
+
+// isTLSError returns true if err, or an error it wraps, is about verifying
+// the certificate or a failed TLS handshake.
+func isTLSError(err error) bool {
+	for err != nil {
+		switch e := err.(type) {
+		case x509.UnknownAuthorityError, x509.CertificateInvalidError, x509.HostnameError,
+			x509.SystemRootsError, x509.ConstraintViolationError, x509.UnhandledCriticalExtension,
+			x509.InsecureAlgorithmError, tls.RecordHeaderError, *thumbprintError:
+			return true
+		case *url.Error:
+			err = e.Err
+			continue
+		case *net.OpError:
+			err = e.Err
+			continue
+		}
+
+		// TLS alerts and handshake failures have unexported types
+		if strings.HasPrefix(err.Error(), "tls: ") {
+			return true
+		}
+
+		wrapper, ok := err.(interface{ Unwrap() error })
+		if !ok {
+			return false
+		}
+		err = wrapper.Unwrap()
+	}
+
+	return false
+}
+
+// isNotAuthenticated returns true if err is a NotAuthenticated fault, which
+// vSphere returns when the session has expired.
+func isNotAuthenticated(err error) bool {
+	switch vimFault(errors.Cause(err)).(type) {
+	case types.NotAuthenticated, *types.NotAuthenticated:
+		return true
+	}
+
+	return false
+}
+
+// vimFault returns the vSphere fault carried by err, or nil if it doesn't
+// carry one.
+func vimFault(err error) interface{} {
+	if e, ok := err.(task.Error); ok {
+		return e.Fault()
+	}
+
+	if soap.IsSoapFault(err) {
+		return soap.ToSoapFault(err).VimFault()
+	}
+
+	if soap.IsVimFault(err) {
+		return soap.ToVimFault(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,37 @@
+package vsphere
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"syscall"
+	"testing"
+)
+
+func TestIsTransient(t *testing.T) {
+	urlError := func(err error) error {
+		return &url.Error{Op: "Post", URL: "https://vcenter.example.com/sdk", Err: err}
+	}
+
+	for _, tc := range []struct {
+		name      string
+		err       error
+		transient bool
+	}{
+		{"connection refused", urlError(&net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}), true},
+		{"connection closed", urlError(io.EOF), true},
+		{"canceled", urlError(context.Canceled), false},
+		{"unsupported scheme", urlError(errors.New("unsupported protocol scheme \"ftp\"")), false},
+		{"unknown authority", urlError(x509.UnknownAuthorityError{}), false},
+		{"hostname mismatch", urlError(x509.HostnameError{Host: "vcenter.example.com"}), false},
+		{"pinned thumbprint", urlError(&thumbprintError{thumbprint: "AB:CD"}), false},
+		{"TLS alert", urlError(&net.OpError{Op: "remote error", Err: errors.New("tls: bad certificate")}), false},
+	} {
+		if isTransient(tc.err) != tc.transient {
+			t.Errorf("%s: expected isTransient to be %v", tc.name, tc.transient)
+		}
+	}
+}
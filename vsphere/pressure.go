@@ -0,0 +1,136 @@
+package vsphere
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	vspherejanitor "github.com/travis-ci/vsphere-janitor"
+	"github.com/vmware/govmomi/object"
+	"github.com/vmware/govmomi/vim25/mo"
+)
+
+// ReadPressure reads the CPU and memory utilization of the resource pool and
+// the storage utilization of the fullest datastore in source. Utilizations
+// of resources that aren't in source are zero.
+func (c *Client) ReadPressure(ctx context.Context, source vspherejanitor.PressureSource) (*vspherejanitor.Pressure, error) {
+	return readPressure(ctx, c, source)
+}
+
+// A pressureLookup gets the resource pools and datastores capacity pressure
+// is read from.
+type pressureLookup interface {
+	resourcePool(ctx context.Context, path string) (*mo.ResourcePool, error)
+	datastore(ctx context.Context, path string) (*mo.Datastore, error)
+}
+
+// readPressure reads the capacity pressure of source with lookup, like
+// ReadPressure.
+func readPressure(ctx context.Context, lookup pressureLookup, source vspherejanitor.PressureSource) (*vspherejanitor.Pressure, error) {
+	pressure := &vspherejanitor.Pressure{}
+
+	if source.ResourcePool != "" {
+		mpool, err := lookup.resourcePool(ctx, source.ResourcePool)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading resource pool %s", source.ResourcePool)
+		}
+
+		pressure.CPU = utilization(mpool.Runtime.Cpu.OverallUsage, mpool.Runtime.Cpu.MaxUsage)
+		pressure.Memory = utilization(mpool.Runtime.Memory.OverallUsage, mpool.Runtime.Memory.MaxUsage)
+	}
+
+	for _, path := range source.Datastores {
+		mdatastore, err := lookup.datastore(ctx, path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading datastore %s", path)
+		}
+
+		summary := mdatastore.Summary
+		storage := utilization(summary.Capacity-summary.FreeSpace, summary.Capacity)
+		if storage > pressure.Storage {
+			pressure.Storage = storage
+		}
+	}
+
+	return pressure, nil
+}
+
+func (c *Client) resourcePool(ctx context.Context, path string) (*mo.ResourcePool, error) {
+	ref, err := c.findByInventoryPath(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, ok := ref.(*object.ResourcePool)
+	if !ok {
+		return nil, errors.Errorf("not a resource pool but a %T", ref)
+	}
+
+	err = vspherejanitor.WaitForRateLimit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	mpool := &mo.ResourcePool{}
+	err = pool.Properties(ctx, pool.Reference(), []string{"runtime"}, mpool)
+	if err != nil {
+		return nil, errors.Wrap(c.classify(ctx, err), "error getting runtime")
+	}
+
+	return mpool, nil
+}
+
+func (c *Client) datastore(ctx context.Context, path string) (*mo.Datastore, error) {
+	ref, err := c.findByInventoryPath(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	datastore, ok := ref.(*object.Datastore)
+	if !ok {
+		return nil, errors.Errorf("not a datastore but a %T", ref)
+	}
+
+	err = vspherejanitor.WaitForRateLimit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	mdatastore := &mo.Datastore{}
+	err = datastore.Properties(ctx, datastore.Reference(), []string{"summary"}, mdatastore)
+	if err != nil {
+		return nil, errors.Wrap(c.classify(ctx, err), "error getting summary")
+	}
+
+	return mdatastore, nil
+}
+
+func (c *Client) findByInventoryPath(ctx context.Context, path string) (object.Reference, error) {
+	client, err := c.sessions.Get(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "couldn't get govmomi client")
+	}
+
+	err = vspherejanitor.WaitForRateLimit(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ref, err := object.NewSearchIndex(client.Client).FindByInventoryPath(ctx, path)
+	if err != nil {
+		return nil, c.classify(ctx, err)
+	}
+
+	if ref == nil {
+		return nil, errors.New("not found")
+	}
+
+	return ref, nil
+}
+
+// utilization returns used as a fraction of max, or zero if max is unknown.
+func utilization(used, max int64) float64 {
+	if max <= 0 {
+		return 0
+	}
+	return float64(used) / float64(max)
+}
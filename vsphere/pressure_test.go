@@ -0,0 +1,85 @@
+package vsphere
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	vspherejanitor "github.com/travis-ci/vsphere-janitor"
+	"github.com/vmware/govmomi/vim25/mo"
+)
+
+type fakePressureLookup struct {
+	resourcePools map[string]*mo.ResourcePool
+	datastores    map[string]*mo.Datastore
+}
+
+func (l *fakePressureLookup) resourcePool(ctx context.Context, path string) (*mo.ResourcePool, error) {
+	mpool, ok := l.resourcePools[path]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return mpool, nil
+}
+
+func (l *fakePressureLookup) datastore(ctx context.Context, path string) (*mo.Datastore, error) {
+	mdatastore, ok := l.datastores[path]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return mdatastore, nil
+}
+
+func resourcePool(cpuUsage, cpuMax, memoryUsage, memoryMax int64) *mo.ResourcePool {
+	mpool := &mo.ResourcePool{}
+	mpool.Runtime.Cpu.OverallUsage = cpuUsage
+	mpool.Runtime.Cpu.MaxUsage = cpuMax
+	mpool.Runtime.Memory.OverallUsage = memoryUsage
+	mpool.Runtime.Memory.MaxUsage = memoryMax
+	return mpool
+}
+
+func datastore(capacity, freeSpace int64) *mo.Datastore {
+	mdatastore := &mo.Datastore{}
+	mdatastore.Summary.Capacity = capacity
+	mdatastore.Summary.FreeSpace = freeSpace
+	return mdatastore
+}
+
+func TestReadPressure(t *testing.T) {
+	lookup := &fakePressureLookup{
+		resourcePools: map[string]*mo.ResourcePool{
+			"/dc/host/cluster/Resources": resourcePool(750, 1000, 512, 2048),
+			"/dc/host/new/Resources":     resourcePool(0, 0, 0, 0),
+		},
+		datastores: map[string]*mo.Datastore{
+			"/dc/datastore/half":  datastore(100, 50),
+			"/dc/datastore/full":  datastore(100, 10),
+			"/dc/datastore/empty": datastore(0, 0),
+		},
+	}
+
+	testCases := []struct {
+		name     string
+		source   vspherejanitor.PressureSource
+		pressure vspherejanitor.Pressure
+		err      bool
+	}{
+		{"resource pool", vspherejanitor.PressureSource{ResourcePool: "/dc/host/cluster/Resources"}, vspherejanitor.Pressure{CPU: 0.75, Memory: 0.25}, false},
+		{"resource pool without limits", vspherejanitor.PressureSource{ResourcePool: "/dc/host/new/Resources"}, vspherejanitor.Pressure{}, false},
+		{"fullest datastore", vspherejanitor.PressureSource{Datastores: []string{"/dc/datastore/half", "/dc/datastore/full", "/dc/datastore/empty"}}, vspherejanitor.Pressure{Storage: 0.9}, false},
+		{"zero capacity datastore", vspherejanitor.PressureSource{Datastores: []string{"/dc/datastore/empty"}}, vspherejanitor.Pressure{}, false},
+		{"missing resource pool", vspherejanitor.PressureSource{ResourcePool: "/dc/host/gone/Resources", Datastores: []string{"/dc/datastore/half"}}, vspherejanitor.Pressure{}, true},
+		{"missing datastore", vspherejanitor.PressureSource{ResourcePool: "/dc/host/cluster/Resources", Datastores: []string{"/dc/datastore/gone"}}, vspherejanitor.Pressure{}, true},
+	}
+
+	for _, tc := range testCases {
+		pressure, err := readPressure(context.TODO(), lookup, tc.source)
+		if (err != nil) != tc.err {
+			t.Errorf("%s: expected error %v, but was %v", tc.name, tc.err, err)
+		}
+		if err == nil && *pressure != tc.pressure {
+			t.Errorf("%s: expected pressure %+v, but was %+v", tc.name, tc.pressure, *pressure)
+		}
+	}
+}
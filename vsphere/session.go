@@ -0,0 +1,249 @@
+package vsphere
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rcrowley/go-metrics"
+	"github.com/travis-ci/vsphere-janitor/log"
+	"github.com/vmware/govmomi"
+	"github.com/vmware/govmomi/session"
+	"github.com/vmware/govmomi/vim25"
+	"github.com/vmware/govmomi/vim25/soap"
+)
+
+// SessionManagerOpts configures a SessionManager.
+type SessionManagerOpts struct {
+	// URL is the URL of the vSphere API. Credentials in it are used unless
+	// Username or Password are set.
+	URL *url.URL
+	TLS TLSOpts
+
+	// Username and Password are the credentials to log in with. Password is
+	// called for every login, so rotated secrets are picked up.
+	Username string
+	Password PasswordSource
+
+	// KeepAliveInterval is how often the session is checked, and
+	// re-established if it has expired. Zero disables keepalive.
+	KeepAliveInterval time.Duration
+
+	// Metrics is the registry login metrics are reported to. It defaults
+	// to metrics.DefaultRegistry.
+	Metrics metrics.Registry
+}
+
+// A SessionManager owns a logged in govmomi client, keeps its session alive
+// and logs in again when the session expires.
+type SessionManager struct {
+	// logins counts login attempts, so concurrent Relogin calls can tell
+	// whether another one has logged in while they waited. It comes first
+	// to be 64-bit aligned for atomic access.
+	logins uint64
+
+	opts SessionManagerOpts
+
+	mutex  sync.Mutex
+	client *govmomi.Client
+	err    error
+}
+
+// NewSessionManager returns a SessionManager that logs in lazily on the
+// first call to Get.
+func NewSessionManager(opts *SessionManagerOpts) *SessionManager {
+	sm := &SessionManager{
+		opts: *opts,
+		err:  errors.New("not logged in yet"),
+	}
+
+	u := *sm.opts.URL
+	if u.User != nil {
+		if sm.opts.Username == "" {
+			sm.opts.Username = u.User.Username()
+		}
+		if password, ok := u.User.Password(); ok && sm.opts.Password == nil {
+			sm.opts.Password = StaticPassword(password)
+		}
+	}
+	u.User = nil
+	sm.opts.URL = &u
+
+	if sm.opts.Password == nil {
+		sm.opts.Password = StaticPassword("")
+	}
+	if sm.opts.Metrics == nil {
+		sm.opts.Metrics = metrics.DefaultRegistry
+	}
+
+	return sm
+}
+
+// Get returns the client, logging in first if there's no valid session.
+func (sm *SessionManager) Get(ctx context.Context) (*govmomi.Client, error) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if sm.client != nil && sm.err == nil {
+		return sm.client, nil
+	}
+
+	err := sm.login(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return sm.client, nil
+}
+
+// Relogin logs in again on the existing client, so objects created from it
+// keep working with the new session. If another login is attempted while
+// Relogin waits for it, such as when several requests find the session
+// expired at the same time, it returns the outcome of that login instead
+// of logging in again.
+func (sm *SessionManager) Relogin(ctx context.Context) error {
+	logins := atomic.LoadUint64(&sm.logins)
+
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if atomic.LoadUint64(&sm.logins) != logins {
+		return sm.err
+	}
+
+	return sm.login(ctx)
+}
+
+// KeepAlive checks the session every KeepAliveInterval until ctx is done,
+// logging in again if it has expired. Checking the session also keeps it
+// from expiring while the janitor is idle between cycles.
+func (sm *SessionManager) KeepAlive(ctx context.Context) {
+	if sm.opts.KeepAliveInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(sm.opts.KeepAliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := sm.check(ctx)
+			if err != nil {
+				log.WithContext(ctx).WithError(err).Warn("vSphere session keepalive failed")
+			}
+		}
+	}
+}
+
+// Healthy returns nil if the last login or session check succeeded, and
+// the error it failed with otherwise.
+func (sm *SessionManager) Healthy() error {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	return sm.err
+}
+
+// Logout ends the session, if there is one.
+func (sm *SessionManager) Logout(ctx context.Context) error {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if sm.client == nil || sm.err != nil {
+		return nil
+	}
+
+	sm.err = errors.New("logged out")
+	return sm.client.Logout(ctx)
+}
+
+func (sm *SessionManager) check(ctx context.Context) error {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if sm.client == nil {
+		return sm.login(ctx)
+	}
+
+	session, err := sm.client.SessionManager.UserSession(ctx)
+	if err == nil && session != nil {
+		sm.err = nil
+		return nil
+	}
+
+	if err != nil && !isNotAuthenticated(err) {
+		sm.err = errors.Wrap(err, "couldn't check session")
+		return sm.err
+	}
+
+	log.WithContext(ctx).Info("vSphere session expired, logging in again")
+	return sm.login(ctx)
+}
+
+// login must be called with the mutex held.
+func (sm *SessionManager) login(ctx context.Context) error {
+	defer atomic.AddUint64(&sm.logins, 1)
+
+	password, err := sm.opts.Password(ctx)
+	if err != nil {
+		sm.err = errors.Wrap(err, "couldn't get vSphere password")
+		return sm.err
+	}
+	user := url.UserPassword(sm.opts.Username, password)
+
+	if sm.client == nil {
+		client, err := sm.newClient(ctx)
+		if err != nil {
+			sm.err = errors.Wrap(err, "couldn't create vSphere client")
+			return sm.err
+		}
+		sm.client = client
+	}
+
+	err = sm.client.Login(ctx, user)
+	if err != nil {
+		sm.err = errors.Wrap(err, "couldn't log in to vSphere")
+		metrics.GetOrRegisterMeter("vsphere.janitor.session.login.errors", sm.opts.Metrics).Mark(1)
+		return sm.err
+	}
+
+	sm.err = nil
+	metrics.GetOrRegisterMeter("vsphere.janitor.session.login", sm.opts.Metrics).Mark(1)
+	return nil
+}
+
+func (sm *SessionManager) newClient(ctx context.Context) (*govmomi.Client, error) {
+	soapClient, err := newSOAPClient(sm.opts.URL, sm.opts.TLS)
+	if err != nil {
+		return nil, err
+	}
+
+	vimClient, err := vim25.NewClient(ctx, soapClient)
+	if err != nil {
+		return nil, err
+	}
+
+	return &govmomi.Client{
+		Client:         vimClient,
+		SessionManager: session.NewManager(vimClient),
+	}, nil
+}
+
+// newSOAPClient returns a SOAP client for u that verifies the vCenter
+// certificate as configured by opts.
+func newSOAPClient(u *url.URL, opts TLSOpts) (*soap.Client, error) {
+	tlsConfig, err := opts.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	soapClient := soap.NewClient(u, tlsConfig.InsecureSkipVerify)
+	soapClient.DefaultTransport().TLSClientConfig = tlsConfig
+	return soapClient, nil
+}
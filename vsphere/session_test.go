@@ -0,0 +1,55 @@
+package vsphere
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	metrics "github.com/rcrowley/go-metrics"
+)
+
+func TestSessionManagerReloginOnce(t *testing.T) {
+	var calls int32
+	sm := NewSessionManager(&SessionManagerOpts{
+		URL: &url.URL{Scheme: "https", Host: "vcenter.example.com", Path: "/sdk"},
+		Password: func(ctx context.Context) (string, error) {
+			atomic.AddInt32(&calls, 1)
+			time.Sleep(100 * time.Millisecond)
+			return "", errors.New("vault is down")
+		},
+		Metrics: metrics.NewRegistry(),
+	})
+
+	wg := sync.WaitGroup{}
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- sm.Relogin(context.TODO())
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err == nil {
+			t.Error("Relogin didn't return the error of the login it waited for")
+		}
+	}
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Errorf("expected concurrent Relogin calls to log in once, but logged in %d times", calls)
+	}
+
+	err := sm.Relogin(context.TODO())
+	if err == nil {
+		t.Error("Relogin didn't return error")
+	}
+	if atomic.LoadInt32(&calls) != 2 {
+		t.Errorf("expected a later Relogin to log in again, but logged in %d times", calls)
+	}
+}
@@ -0,0 +1,114 @@
+package vsphere
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// TLSOpts configures how the vCenter certificate is verified.
+type TLSOpts struct {
+	// Insecure disables certificate verification. It is ignored if CAFile
+	// or Thumbprints are set.
+	Insecure bool
+
+	// CAFile is a PEM bundle of CAs to verify the certificate with,
+	// instead of the system CAs.
+	CAFile string
+
+	// Thumbprints pins the certificate to one of these SHA-1 or SHA-256
+	// fingerprints, written as hex with or without colons, like vCenter
+	// shows them. The certificate chain isn't verified when pinning.
+	Thumbprints []string
+}
+
+// Config returns the tls.Config to connect to vCenter with.
+func (o *TLSOpts) Config() (*tls.Config, error) {
+	config := &tls.Config{}
+
+	if len(o.Thumbprints) > 0 {
+		thumbprints := make([][]byte, 0, len(o.Thumbprints))
+		for _, thumbprint := range o.Thumbprints {
+			b, err := parseThumbprint(thumbprint)
+			if err != nil {
+				return nil, err
+			}
+			thumbprints = append(thumbprints, b)
+		}
+
+		// chain verification is replaced by comparing the leaf certificate
+		// with the pinned thumbprints
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyThumbprint(rawCerts, thumbprints)
+		}
+		return config, nil
+	}
+
+	if o.CAFile != "" {
+		pem, err := ioutil.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "couldn't read CA file")
+		}
+
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificates found in CA file %s", o.CAFile)
+		}
+		return config, nil
+	}
+
+	config.InsecureSkipVerify = o.Insecure
+	return config, nil
+}
+
+func parseThumbprint(thumbprint string) ([]byte, error) {
+	b, err := hex.DecodeString(strings.Replace(thumbprint, ":", "", -1))
+	if err != nil || (len(b) != sha1.Size && len(b) != sha256.Size) {
+		return nil, errors.Errorf("invalid thumbprint %q, expected a SHA-1 or SHA-256 fingerprint in hex", thumbprint)
+	}
+
+	return b, nil
+}
+
+func verifyThumbprint(rawCerts [][]byte, thumbprints [][]byte) error {
+	if len(rawCerts) == 0 {
+		return errors.New("no certificate presented")
+	}
+
+	sha1Sum := sha1.Sum(rawCerts[0])
+	sha256Sum := sha256.Sum256(rawCerts[0])
+	for _, thumbprint := range thumbprints {
+		if bytes.Equal(thumbprint, sha1Sum[:]) || bytes.Equal(thumbprint, sha256Sum[:]) {
+			return nil
+		}
+	}
+
+	return &thumbprintError{thumbprint: formatThumbprint(sha1Sum[:])}
+}
+
+// thumbprintError is returned when the certificate doesn't match any pinned
+// thumbprint.
+type thumbprintError struct {
+	thumbprint string
+}
+
+func (e *thumbprintError) Error() string {
+	return "certificate thumbprint " + e.thumbprint + " doesn't match any pinned thumbprint"
+}
+
+// formatThumbprint formats b like vCenter shows thumbprints.
+func formatThumbprint(b []byte) string {
+	parts := make([]string, len(b))
+	for i := range b {
+		parts[i] = strings.ToUpper(hex.EncodeToString(b[i : i+1]))
+	}
+	return strings.Join(parts, ":")
+}
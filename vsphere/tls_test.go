@@ -0,0 +1,131 @@
+package vsphere
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TestTLSOpts uses a plain TLS server rather than vcsim, since the govmomi
+// simulator isn't vendored. The SOAP client it connects with is the one
+// sessions are made with, so the verification is the same.
+func TestTLSOpts(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+
+	cert := server.Certificate()
+	sum := sha256.Sum256(cert.Raw)
+
+	dir, err := ioutil.TempDir("", "vsphere-janitor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	caFile := filepath.Join(dir, "ca.pem")
+	err = ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherCA := filepath.Join(dir, "other.pem")
+	err = ioutil.WriteFile(otherCA, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: selfSignedCert(t)}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		opts TLSOpts
+		ok   bool
+	}{
+		{name: "verify with system CAs", opts: TLSOpts{}, ok: false},
+		{name: "insecure", opts: TLSOpts{Insecure: true}, ok: true},
+		{name: "CA file", opts: TLSOpts{CAFile: caFile}, ok: true},
+		{name: "CA file overrides insecure", opts: TLSOpts{Insecure: true, CAFile: otherCA}, ok: false},
+		{name: "missing CA file", opts: TLSOpts{Insecure: true, CAFile: filepath.Join(dir, "missing.pem")}, ok: false},
+		{name: "matching SHA-256 thumbprint", opts: TLSOpts{Thumbprints: []string{formatThumbprint(sum[:])}}, ok: true},
+		{name: "matching SHA-1 thumbprint", opts: TLSOpts{Thumbprints: []string{sha1Thumbprint(cert.Raw)}}, ok: true},
+		{name: "wrong thumbprint", opts: TLSOpts{Insecure: true, Thumbprints: []string{formatThumbprint(make([]byte, 20))}}, ok: false},
+	} {
+		err := get(server.URL, tc.opts)
+		if tc.ok && err != nil {
+			t.Errorf("%s: request failed: %v", tc.name, err)
+		} else if !tc.ok && err == nil {
+			t.Errorf("%s: request succeeded", tc.name)
+		} else if err != nil && isTransient(err) {
+			t.Errorf("%s: error is classified as transient: %v", tc.name, err)
+		}
+	}
+
+	err = errors.Wrap(get(server.URL, TLSOpts{}), "couldn't log in to vSphere")
+	if !IsCertificateError(err) {
+		t.Errorf("expected %v to be a certificate error", err)
+	}
+}
+
+// get requests url with the SOAP client vCenter sessions are made with.
+func get(rawURL string, opts TLSOpts) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+
+	soapClient, err := newSOAPClient(u, opts)
+	if err != nil {
+		return err
+	}
+
+	resp, err := soapClient.Client.Get(u.String())
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func sha1Thumbprint(raw []byte) string {
+	sum := sha1.Sum(raw)
+	return formatThumbprint(sum[:])
+}
+
+func selfSignedCert(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "other CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}